@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	scopeFilesRead  = "files:read"
+	scopeFilesWrite = "files:write"
+	scopeIntroWrite = "intro:write"
+
+	apiTokenPrefix = "wsb_"
+)
+
+var knownScopes = []string{scopeFilesRead, scopeFilesWrite, scopeIntroWrite}
+
+var (
+	errTokenInvalid  = errors.New("token de API inválido o expirado")
+	errTokenNotFound = errors.New("token no encontrado")
+)
+
+// apiToken es un token personal para bots y scripts. Solo se guarda el
+// SHA-256 del secreto; Prefix sirve para reconocerlo en el listado. Role es
+// el rol del usuario al crearlo y nunca puede ser mayor; si después le quitan
+// roles en Discord, capUserRole lo baja.
+type apiToken struct {
+	ID            string     `bson:"_id" json:"id"`
+	Hash          string     `bson:"hash" json:"-"`
+	Prefix        string     `bson:"prefix" json:"prefix"`
+	Name          string     `bson:"name" json:"name"`
+	Scopes        []string   `bson:"scopes" json:"scopes"`
+	UserID        string     `bson:"userId" json:"-"`
+	Username      string     `bson:"username" json:"-"`
+	Discriminator string     `bson:"discriminator" json:"-"`
+	Avatar        string     `bson:"avatar" json:"-"`
+	Role          string     `bson:"role" json:"role"`
+	CreatedAt     time.Time  `bson:"createdAt" json:"createdAt"`
+	LastUsedAt    *time.Time `bson:"lastUsedAt,omitempty" json:"lastUsedAt,omitempty"`
+	ExpiresAt     *time.Time `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+}
+
+func (s *server) ensureTokenIndexes(ctx context.Context) error {
+	_, err := s.tokensCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func parseScopes(raw []string) ([]string, error) {
+	var scopes []string
+	for _, scope := range raw {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if !slices.Contains(knownScopes, scope) {
+			return nil, fmt.Errorf("permiso desconocido %q, usa %s", scope, strings.Join(knownScopes, ", "))
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("se requiere al menos un permiso: %s", strings.Join(knownScopes, ", "))
+	}
+	return scopes, nil
+}
+
+// createAPIToken devuelve el token en claro; es la única vez que se ve.
+func (s *server) createAPIToken(ctx context.Context, claims *jwtClaims, name string, scopes []string, ttl time.Duration) (string, apiToken, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", apiToken{}, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", apiToken{}, err
+	}
+
+	plain := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	now := time.Now().UTC()
+	token := apiToken{
+		ID:            hex.EncodeToString(id),
+		Hash:          hashAPIToken(plain),
+		Prefix:        plain[:len(apiTokenPrefix)+6],
+		Name:          name,
+		Scopes:        scopes,
+		UserID:        claims.UserID,
+		Username:      claims.Username,
+		Discriminator: claims.Discriminator,
+		Avatar:        claims.Avatar,
+		Role:          string(s.auth.roleOf(claims)),
+		CreatedAt:     now,
+	}
+	if ttl > 0 {
+		expires := now.Add(ttl)
+		token.ExpiresAt = &expires
+	}
+
+	if _, err := s.tokensCollection.InsertOne(ctx, token); err != nil {
+		return "", apiToken{}, err
+	}
+	return plain, token, nil
+}
+
+func (s *server) listAPITokens(ctx context.Context, userID string) ([]apiToken, error) {
+	cursor, err := s.tokensCollection.Find(ctx, bson.M{"userId": userID}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	tokens := []apiToken{}
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (s *server) revokeAPIToken(ctx context.Context, userID, id string) error {
+	res, err := s.tokensCollection.DeleteOne(ctx, bson.M{"_id": id, "userId": userID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return errTokenNotFound
+	}
+	return nil
+}
+
+func (s *server) revokeUserAPITokens(ctx context.Context, userID string) (int64, error) {
+	res, err := s.tokensCollection.DeleteMany(ctx, bson.M{"userId": userID})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+// capUserAPITokens baja al rol actual del usuario los tokens que quedaron con
+// uno mayor. Nunca los sube: un ascenso no amplía tokens ya emitidos.
+func (s *server) capUserAPITokens(ctx context.Context, userID string, role wasabiRole) error {
+	above := rolesAbove(role)
+	if len(above) == 0 {
+		return nil
+	}
+
+	res, err := s.tokensCollection.UpdateMany(ctx,
+		bson.M{"userId": userID, "role": bson.M{"$in": above}},
+		bson.M{"$set": bson.M{"role": string(role)}},
+	)
+	if err != nil {
+		return fmt.Errorf("no se pudo ajustar el rol de los tokens de %s: %w", userID, err)
+	}
+	if res.ModifiedCount > 0 {
+		log.Printf("tokens de API: user_id=%s rol=%s ajustados=%d", userID, role, res.ModifiedCount)
+	}
+	return nil
+}
+
+// authenticateAPIToken arma las claims del dueño del token. El índice TTL
+// borra los vencidos, pero puede tardar hasta un minuto.
+func (s *server) authenticateAPIToken(ctx context.Context, plain string) (*jwtClaims, error) {
+	if !strings.HasPrefix(plain, apiTokenPrefix) {
+		return nil, errTokenInvalid
+	}
+
+	var token apiToken
+	err := s.tokensCollection.FindOne(ctx, bson.M{"hash": hashAPIToken(plain)}).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if token.ExpiresAt != nil && !token.ExpiresAt.After(now) {
+		return nil, errTokenInvalid
+	}
+
+	// No hace falta registrar cada petición de un bot.
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > time.Minute {
+		if _, err := s.tokensCollection.UpdateOne(ctx, bson.M{"_id": token.ID}, bson.M{"$set": bson.M{"lastUsedAt": now}}); err != nil {
+			log.Printf("no se pudo registrar uso del token %s: %v", token.ID, err)
+		}
+	}
+
+	return &jwtClaims{
+		UserID:        token.UserID,
+		Username:      token.Username,
+		Discriminator: token.Discriminator,
+		Avatar:        token.Avatar,
+		GuildID:       s.auth.requiredGuildID,
+		Role:          token.Role,
+		TokenID:       token.ID,
+		Scopes:        token.Scopes,
+	}, nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errUnreadableAudio = errors.New("el archivo no contiene audio válido")
+
+type audioInfo struct {
+	Duration   float64 `bson:"duration" json:"duration"`
+	SampleRate int     `bson:"sampleRate" json:"sampleRate"`
+	Channels   int     `bson:"channels" json:"channels"`
+	Bitrate    int64   `bson:"bitrate" json:"bitrate"`
+	Codec      string  `bson:"codec" json:"codec"`
+}
+
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecType  string `json:"codec_type"`
+		CodecName  string `json:"codec_name"`
+		SampleRate string `json:"sample_rate"`
+		Channels   int    `json:"channels"`
+		BitRate    string `json:"bit_rate"`
+		Duration   string `json:"duration"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+		BitRate  string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+func (f *ffmpegRunner) probeAudio(ctx context.Context, path string) (audioInfo, error) {
+	stdout, err := f.ffprobe(ctx, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", path)
+	if isFFmpegTimeout(err) || errors.Is(err, context.Canceled) {
+		return audioInfo{}, err
+	}
+	if err != nil {
+		return audioInfo{}, fmt.Errorf("%w: ffprobe falló: %v", errUnreadableAudio, err)
+	}
+
+	var out ffprobeOutput
+	if err := json.Unmarshal(stdout, &out); err != nil {
+		return audioInfo{}, fmt.Errorf("respuesta de ffprobe inválida: %w", err)
+	}
+
+	for _, stream := range out.Streams {
+		if stream.CodecType != "audio" {
+			continue
+		}
+
+		info := audioInfo{
+			Codec:    stream.CodecName,
+			Channels: stream.Channels,
+		}
+		info.SampleRate, _ = strconv.Atoi(stream.SampleRate)
+		info.Duration = parseProbeFloat(out.Format.Duration, stream.Duration)
+		info.Bitrate = int64(parseProbeFloat(stream.BitRate, out.Format.BitRate))
+
+		if info.Duration <= 0 {
+			return audioInfo{}, fmt.Errorf("%w: duración desconocida", errUnreadableAudio)
+		}
+		return info, nil
+	}
+
+	return audioInfo{}, fmt.Errorf("%w: sin pistas de audio", errUnreadableAudio)
+}
+
+func parseProbeFloat(values ...string) float64 {
+	for _, v := range values {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 {
+			return f
+		}
+	}
+	return 0
+}
+
+type loudnessStats struct {
+	IntegratedLUFS float64 `bson:"integrated" json:"integrated"`
+	TruePeak       float64 `bson:"truePeak" json:"truePeak"`
+	LRA            float64 `bson:"lra" json:"lra"`
+	Threshold      float64 `bson:"threshold" json:"threshold"`
+	TargetOffset   float64 `bson:"targetOffset,omitempty" json:"targetOffset,omitempty"`
+}
+
+type loudnormTarget struct {
+	IntegratedLUFS float64 `bson:"integrated" json:"integrated"`
+	TruePeak       float64 `bson:"truePeak" json:"truePeak"`
+	LRA            float64 `bson:"lra" json:"lra"`
+}
+
+type loudnessRecord struct {
+	Input        loudnessStats  `bson:"input" json:"input"`
+	Output       loudnessStats  `bson:"output" json:"output"`
+	Target       loudnormTarget `bson:"target" json:"target"`
+	NormalizedAt time.Time      `bson:"normalizedAt" json:"normalizedAt"`
+}
+
+type loudnormReport struct {
+	Input  loudnessStats
+	Output loudnessStats
+}
+
+func (t loudnormTarget) filterArgs() []string {
+	if t == (loudnormTarget{}) {
+		return nil
+	}
+	return []string{
+		"I=" + formatFilterFloat(t.IntegratedLUFS),
+		"TP=" + formatFilterFloat(t.TruePeak),
+		"LRA=" + formatFilterFloat(t.LRA),
+	}
+}
+
+// measureLoudness ejecuta la pasada de análisis de loudnorm (EBU R128) y
+// devuelve los valores medidos del archivo.
+func (f *ffmpegRunner) measureLoudness(ctx context.Context, path string, target loudnormTarget) (loudnessStats, error) {
+	filter := "loudnorm=" + strings.Join(append(target.filterArgs(), "print_format=json"), ":")
+
+	stderr, err := f.ffmpeg(ctx, "-i", path, "-vn", "-af", filter, "-f", "null", "-")
+	if err != nil {
+		return loudnessStats{}, fmt.Errorf("ffmpeg no pudo medir la sonoridad: %w", err)
+	}
+
+	report, err := parseLoudnormOutput(stderr)
+	if err != nil {
+		return loudnessStats{}, err
+	}
+	return report.Input, nil
+}
+
+// normalizeToMP3 aplica loudnorm en dos pasadas: la primera mide el audio y la
+// segunda corrige linealmente usando esas mediciones.
+func (f *ffmpegRunner) normalizeToMP3(ctx context.Context, inputPath, outputPath string, target loudnormTarget) (loudnessRecord, error) {
+	measured, err := f.measureLoudness(ctx, inputPath, target)
+	if err != nil {
+		return loudnessRecord{}, err
+	}
+
+	args := append(target.filterArgs(),
+		"measured_I="+formatFilterFloat(measured.IntegratedLUFS),
+		"measured_TP="+formatFilterFloat(measured.TruePeak),
+		"measured_LRA="+formatFilterFloat(measured.LRA),
+		"measured_thresh="+formatFilterFloat(measured.Threshold),
+		"offset="+formatFilterFloat(measured.TargetOffset),
+		"linear=true",
+		"print_format=json",
+	)
+
+	stderr, err := f.ffmpeg(ctx, append([]string{"-y", "-i", inputPath, "-vn",
+		"-af", "loudnorm=" + strings.Join(args, ":"), "-ar", "48000",
+		"-codec:a", "libmp3lame", "-qscale:a", "2"}, f.outputArgs(outputPath)...)...)
+	if err != nil {
+		return loudnessRecord{}, fmt.Errorf("ffmpeg no pudo normalizar el archivo: %w", err)
+	}
+	if err := f.checkOutput(outputPath); err != nil {
+		return loudnessRecord{}, err
+	}
+
+	report, err := parseLoudnormOutput(stderr)
+	if err != nil {
+		return loudnessRecord{}, err
+	}
+
+	return loudnessRecord{
+		Input:        measured,
+		Output:       report.Output,
+		Target:       target,
+		NormalizedAt: time.Now().UTC(),
+	}, nil
+}
+
+func parseLoudnormOutput(output []byte) (loudnormReport, error) {
+	start := bytes.LastIndexByte(output, '{')
+	end := bytes.LastIndexByte(output, '}')
+	if start < 0 || end < start {
+		return loudnormReport{}, fmt.Errorf("ffmpeg no devolvió mediciones de loudnorm")
+	}
+
+	var raw map[string]string
+	if err := json.Unmarshal(output[start:end+1], &raw); err != nil {
+		return loudnormReport{}, fmt.Errorf("mediciones de loudnorm inválidas: %w", err)
+	}
+
+	value := func(key string) float64 {
+		f, _ := strconv.ParseFloat(raw[key], 64)
+		return f
+	}
+
+	return loudnormReport{
+		Input: loudnessStats{
+			IntegratedLUFS: value("input_i"),
+			TruePeak:       value("input_tp"),
+			LRA:            value("input_lra"),
+			Threshold:      value("input_thresh"),
+			TargetOffset:   value("target_offset"),
+		},
+		Output: loudnessStats{
+			IntegratedLUFS: value("output_i"),
+			TruePeak:       value("output_tp"),
+			LRA:            value("output_lra"),
+			Threshold:      value("output_thresh"),
+		},
+	}, nil
+}
+
+func formatFilterFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+type editOptions struct {
+	Start            float64
+	End              float64
+	FadeIn           float64
+	FadeOut          float64
+	TrimSilence      bool
+	SilenceThreshold float64
+}
+
+// editFilters arma la cadena de filtros de ffmpeg. El silencio final y el
+// fade-out se aplican sobre el audio invertido para no depender de la duración.
+func (o editOptions) filters() []string {
+	var filters []string
+
+	if o.Start > 0 || o.End > 0 {
+		trim := "atrim=start=" + formatFilterFloat(o.Start)
+		if o.End > 0 {
+			trim += ":end=" + formatFilterFloat(o.End)
+		}
+		filters = append(filters, trim, "asetpts=PTS-STARTPTS")
+	}
+
+	if o.TrimSilence {
+		silence := "silenceremove=start_periods=1:start_silence=0.05:start_threshold=" + formatFilterFloat(o.SilenceThreshold) + "dB"
+		filters = append(filters, silence, "areverse", silence, "areverse")
+	}
+
+	if o.FadeIn > 0 {
+		filters = append(filters, "afade=t=in:st=0:d="+formatFilterFloat(o.FadeIn))
+	}
+
+	if o.FadeOut > 0 {
+		filters = append(filters, "areverse", "afade=t=in:st=0:d="+formatFilterFloat(o.FadeOut), "areverse")
+	}
+
+	return filters
+}
+
+func (f *ffmpegRunner) editToMP3(ctx context.Context, inputPath, outputPath string, opts editOptions) error {
+	_, err := f.ffmpeg(ctx, append([]string{"-y", "-i", inputPath, "-vn",
+		"-af", strings.Join(opts.filters(), ","),
+		"-codec:a", "libmp3lame", "-qscale:a", "2"}, f.outputArgs(outputPath)...)...)
+	if err != nil {
+		return fmt.Errorf("ffmpeg no pudo editar el archivo: %w", err)
+	}
+	return f.checkOutput(outputPath)
+}
+
+func (f *ffmpegRunner) convertToMP3(ctx context.Context, inputPath, outputPath string) error {
+	return f.transcode(ctx, inputPath, outputPath, []string{"-codec:a", "libmp3lame", "-qscale:a", "2"})
+}
+
+// transcode convierte solo la pista de audio con los argumentos de códec dados.
+func (f *ffmpegRunner) transcode(ctx context.Context, inputPath, outputPath string, codecArgs []string) error {
+	args := append([]string{"-y", "-i", inputPath, "-vn"}, codecArgs...)
+	if _, err := f.ffmpeg(ctx, append(args, f.outputArgs(outputPath)...)...); err != nil {
+		return fmt.Errorf("ffmpeg no pudo convertir el archivo: %w", err)
+	}
+	return f.checkOutput(outputPath)
+}
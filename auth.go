@@ -2,13 +2,20 @@ package main
 
 import (
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -18,6 +25,8 @@ type contextKey string
 
 const userContextKey contextKey = "user"
 
+const defaultDiscordBaseURL = "https://discord.com"
+
 type oauth2Config struct {
 	clientID     string
 	clientSecret string
@@ -37,16 +46,87 @@ type jwtClaims struct {
 	Discriminator string `json:"discriminator"`
 	Avatar        string `json:"avatar"`
 	GuildID       string `json:"guild_id"`
+	Role          string `json:"role"`
 	jwt.RegisteredClaims
+
+	// TokenID y Scopes solo se completan al autenticar con un token de API;
+	// nunca viajan dentro del JWT.
+	TokenID string   `json:"-"`
+	Scopes  []string `json:"-"`
 }
 
 type authService struct {
 	config          oauth2Config
 	jwtSecret       []byte
 	requiredGuildID string
+	adminUserIDs    map[string]bool
+	roleByDiscordID map[string]wasabiRole
+	defaultRole     wasabiRole
+	accessTTL       time.Duration
+	refreshTTL      time.Duration
+	recheckInterval time.Duration
+	tokenCipher     cipher.AEAD
+	botToken        string
+	discordBaseURL  string
+	httpClient      *http.Client
 }
 
+// discordToken es la respuesta del endpoint de tokens de Discord. El refresh
+// token se guarda cifrado en la sesión para volver a consultar Discord sin
+// pedir login.
+type discordToken struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// errDiscordGrantRevoked indica que Discord ya no acepta el refresh token,
+// por ejemplo porque el usuario desautorizó la aplicación.
+var errDiscordGrantRevoked = errors.New("Discord revocó la autorización")
+
 func newAuthService(cfg authConfig) *authService {
+	admins := make(map[string]bool, len(cfg.AdminUserIDs))
+	for _, id := range cfg.AdminUserIDs {
+		admins[id] = true
+	}
+
+	roles := make(map[string]wasabiRole)
+	for role, ids := range cfg.RoleIDs {
+		for _, id := range ids {
+			if current, ok := roles[id]; ok && roleRank[current] >= roleRank[role] {
+				continue
+			}
+			roles[id] = role
+		}
+	}
+
+	defaultRole := cfg.DefaultRole
+	if defaultRole == "" {
+		defaultRole = roleListener
+	}
+
+	// La clave de cifrado de los tokens de Discord se deriva de JWT_SECRET;
+	// cambiar el secreto invalida todas las sesiones de todas formas.
+	key := sha256.Sum256([]byte("wasabi discord tokens\x00" + cfg.JWTSecret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+
+	baseURL := strings.TrimRight(cfg.DiscordBaseURL, "/")
+	if baseURL == "" {
+		baseURL = defaultDiscordBaseURL
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 15 * time.Second}
+	}
+
 	return &authService{
 		config: oauth2Config{
 			clientID:     cfg.ClientID,
@@ -55,58 +135,85 @@ func newAuthService(cfg authConfig) *authService {
 		},
 		jwtSecret:       []byte(cfg.JWTSecret),
 		requiredGuildID: cfg.RequiredGuildID,
+		adminUserIDs:    admins,
+		roleByDiscordID: roles,
+		defaultRole:     defaultRole,
+		accessTTL:       cfg.AccessTokenTTL,
+		refreshTTL:      cfg.RefreshTokenTTL,
+		recheckInterval: cfg.RecheckInterval,
+		tokenCipher:     aead,
+		botToken:        cfg.BotToken,
+		discordBaseURL:  baseURL,
+		httpClient:      client,
 	}
 }
 
+func (a *authService) discordAPI(path string) string {
+	return a.discordBaseURL + "/api" + path
+}
+
 func (a *authService) authURL(state string) string {
 	return fmt.Sprintf(
-		"https://discord.com/oauth2/authorize?client_id=%s&redirect_uri=%s&response_type=code&scope=%s&state=%s",
+		"%s/oauth2/authorize?client_id=%s&redirect_uri=%s&response_type=code&scope=%s&state=%s",
+		a.discordBaseURL,
 		url.QueryEscape(a.config.clientID),
 		url.QueryEscape(a.config.redirectURI),
-		url.QueryEscape("identify guilds"),
+		url.QueryEscape("identify guilds guilds.members.read"),
 		url.QueryEscape(state),
 	)
 }
 
-func (a *authService) exchangeCodeForToken(code string) (string, error) {
+func (a *authService) exchangeCodeForToken(code string) (*discordToken, error) {
 	data := url.Values{}
-	data.Set("client_id", a.config.clientID)
-	data.Set("client_secret", a.config.clientSecret)
 	data.Set("grant_type", "authorization_code")
 	data.Set("code", code)
 	data.Set("redirect_uri", a.config.redirectURI)
+	return a.requestToken(data)
+}
 
-	resp, err := http.PostForm("https://discord.com/api/oauth2/token", data)
+// refreshDiscordToken pide un access token nuevo. Discord también rota el
+// refresh token, así que hay que guardar el que devuelve.
+func (a *authService) refreshDiscordToken(refreshToken string) (*discordToken, error) {
+	data := url.Values{}
+	data.Set("grant_type", "refresh_token")
+	data.Set("refresh_token", refreshToken)
+	return a.requestToken(data)
+}
+
+func (a *authService) requestToken(data url.Values) (*discordToken, error) {
+	data.Set("client_id", a.config.clientID)
+	data.Set("client_secret", a.config.clientSecret)
+
+	resp, err := a.httpClient.PostForm(a.discordAPI("/oauth2/token"), data)
 	if err != nil {
-		return "", fmt.Errorf("error en petición: %w", err)
+		return nil, fmt.Errorf("error en petición: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("código de estado: %d", resp.StatusCode)
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf("%w: código de estado %d", errDiscordGrantRevoked, resp.StatusCode)
 	}
-
-	var result struct {
-		AccessToken string `json:"access_token"`
-		TokenType   string `json:"token_type"`
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("código de estado: %d", resp.StatusCode)
 	}
 
+	var result discordToken
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("error al decodificar respuesta: %w", err)
+		return nil, fmt.Errorf("error al decodificar respuesta: %w", err)
 	}
 
-	return result.AccessToken, nil
+	return &result, nil
 }
 
 func (a *authService) fetchDiscordUser(accessToken string) (*discordUser, error) {
-	req, err := http.NewRequest("GET", "https://discord.com/api/users/@me", nil)
+	req, err := http.NewRequest("GET", a.discordAPI("/users/@me"), nil)
 	if err != nil {
 		return nil, fmt.Errorf("error al crear petición: %w", err)
 	}
 
 	req.Header.Set("Authorization", "Bearer "+accessToken)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := a.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("error en petición: %w", err)
 	}
@@ -129,14 +236,14 @@ func (a *authService) isMemberOfRequiredGuild(accessToken string) (bool, error)
 		return true, nil
 	}
 
-	req, err := http.NewRequest("GET", "https://discord.com/api/users/@me/guilds", nil)
+	req, err := http.NewRequest("GET", a.discordAPI("/users/@me/guilds"), nil)
 	if err != nil {
 		return false, fmt.Errorf("error al crear petición: %w", err)
 	}
 
 	req.Header.Set("Authorization", "Bearer "+accessToken)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := a.httpClient.Do(req)
 	if err != nil {
 		return false, fmt.Errorf("error en petición: %w", err)
 	}
@@ -162,7 +269,41 @@ func (a *authService) isMemberOfRequiredGuild(accessToken string) (bool, error)
 	return false, nil
 }
 
-func (a *authService) generateJWT(user *discordUser) (string, error) {
+func (a *authService) fetchGuildMemberRoles(accessToken string) ([]string, error) {
+	if a.requiredGuildID == "" {
+		return nil, nil
+	}
+
+	req, err := http.NewRequest("GET", a.discordAPI("/users/@me/guilds/"+url.PathEscape(a.requiredGuildID)+"/member"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error al crear petición: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error en petición: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("código de estado: %d", resp.StatusCode)
+	}
+
+	var member struct {
+		Roles []string `json:"roles"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&member); err != nil {
+		return nil, fmt.Errorf("error al decodificar miembro: %w", err)
+	}
+
+	return member.Roles, nil
+}
+
+// generateJWT firma un access token de corta duración para la sesión
+// sessionID; el registro en Mongo es el que permite revocarla antes.
+func (a *authService) generateJWT(user *discordUser, role wasabiRole, sessionID string) (string, error) {
 	now := time.Now()
 	claims := jwtClaims{
 		UserID:        user.ID,
@@ -170,8 +311,10 @@ func (a *authService) generateJWT(user *discordUser) (string, error) {
 		Discriminator: user.Discriminator,
 		Avatar:        user.Avatar,
 		GuildID:       a.requiredGuildID,
+		Role:          string(role),
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(now.Add(24 * time.Hour)),
+			ID:        sessionID,
+			ExpiresAt: jwt.NewNumericDate(now.Add(a.accessTTL)),
 			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
@@ -199,6 +342,58 @@ func (a *authService) validateJWT(tokenString string) (*jwtClaims, error) {
 	return nil, fmt.Errorf("token inválido")
 }
 
+// signRefreshToken arma el refresh token de la generación gen de una sesión.
+// Va firmado para que un token viejo se pueda reconocer como propio y
+// detectar su reutilización sin guardar cada uno.
+func (a *authService) signRefreshToken(sessionID string, gen int) string {
+	payload := sessionID + "." + strconv.Itoa(gen)
+	return payload + "." + a.refreshMAC(payload)
+}
+
+func (a *authService) parseRefreshToken(token string) (string, int, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", 0, errRefreshInvalid
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(a.refreshMAC(payload))) {
+		return "", 0, errRefreshInvalid
+	}
+	gen, err := strconv.Atoi(parts[1])
+	if err != nil || gen < 0 {
+		return "", 0, errRefreshInvalid
+	}
+	return parts[0], gen, nil
+}
+
+func (a *authService) refreshMAC(payload string) string {
+	mac := hmac.New(sha256.New, a.jwtSecret)
+	mac.Write([]byte("refresh\x00" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (a *authService) sealToken(plain string) (string, error) {
+	nonce := make([]byte, a.tokenCipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := a.tokenCipher.Seal(nonce, nonce, []byte(plain), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (a *authService) openToken(sealed string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < a.tokenCipher.NonceSize() {
+		return "", fmt.Errorf("token cifrado inválido")
+	}
+	nonce, ciphertext := raw[:a.tokenCipher.NonceSize()], raw[a.tokenCipher.NonceSize():]
+	plain, err := a.tokenCipher.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("no se pudo descifrar el token: %w", err)
+	}
+	return string(plain), nil
+}
+
 func generateRandomState() string {
 	b := make([]byte, 32)
 	if _, err := rand.Read(b); err != nil {
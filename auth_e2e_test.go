@@ -0,0 +1,585 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// Las pruebas recorren el login completo contra fakeDiscord. MongoDB se simula
+// con mtest: cada prueba encola, en orden, las respuestas de los comandos que
+// el flujo debería ejecutar.
+
+var (
+	alice = discordUser{ID: "1001", Username: "alice", Discriminator: "0", Avatar: "a1"}
+	bob   = discordUser{ID: "1002", Username: "bob", Discriminator: "0", Avatar: "b1"}
+)
+
+type authHarness struct {
+	mt       *mtest.T
+	discord  *fakeDiscord
+	srv      *server
+	app      *httptest.Server
+	frontend *httptest.Server
+	client   *http.Client
+}
+
+func newAuthHarness(mt *mtest.T) *authHarness {
+	mt.Helper()
+
+	h := &authHarness{mt: mt, discord: newFakeDiscord(mt.T)}
+	h.frontend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("frontend"))
+	}))
+	mt.Cleanup(h.frontend.Close)
+
+	// La redirect URI necesita la dirección del servidor antes de armarlo.
+	var handler http.Handler
+	h.app = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+	}))
+	mt.Cleanup(h.app.Close)
+
+	h.srv = &server{
+		auth: newAuthService(authConfig{
+			ClientID:        fakeClientID,
+			ClientSecret:    fakeClientSecret,
+			RedirectURI:     h.app.URL + "/auth/discord/callback",
+			JWTSecret:       "secreto-de-prueba",
+			RequiredGuildID: fakeGuildID,
+			RoleIDs:         map[wasabiRole][]string{roleModerator: {"rol-moderador"}},
+			DefaultRole:     roleListener,
+			AccessTokenTTL:  15 * time.Minute,
+			RefreshTokenTTL: 720 * time.Hour,
+			RecheckInterval: time.Hour,
+			BotToken:        fakeBotToken,
+			DiscordBaseURL:  h.discord.URL,
+			HTTPClient:      h.discord.Client(),
+		}),
+		frontendOrigin:     h.frontend.URL,
+		sessionsCollection: mt.Coll,
+		tokensCollection:   mt.Coll,
+		membership:         newMembershipCache(time.Minute),
+	}
+	handler = h.srv.routes()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		mt.Fatal(err)
+	}
+	h.client = &http.Client{Jar: jar}
+	return h
+}
+
+// login recorre /auth/discord → Discord → callback → frontend como lo haría
+// el navegador, y devuelve el registro de sesión que se insertó.
+func (h *authHarness) login(user discordUser) bson.D {
+	h.mt.Helper()
+
+	h.discord.login(user.ID)
+	// Se ajustan los tokens de API y las sesiones al rol actual y se inserta
+	// la sesión.
+	h.mt.AddMockResponses(mockUpdated(0), mockUpdated(0), mtest.CreateSuccessResponse())
+
+	resp := h.get("/auth/discord")
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Request.URL.String(), h.frontend.URL) {
+		h.mt.Fatalf("el login terminó en %s con %d", resp.Request.URL, resp.StatusCode)
+	}
+	if resp.Request.URL.Query().Get("error") != "" {
+		h.mt.Fatalf("el login volvió con error: %s", resp.Request.URL)
+	}
+
+	insert := h.startedCommand("insert")
+	var docs []bson.D
+	if err := insert.Command.Lookup("documents").Unmarshal(&docs); err != nil || len(docs) != 1 {
+		h.mt.Fatalf("no se insertó la sesión: %v", err)
+	}
+	return docs[0]
+}
+
+func (h *authHarness) get(path string) *http.Response {
+	h.mt.Helper()
+	resp, err := h.client.Get(h.app.URL + path)
+	if err != nil {
+		h.mt.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func (h *authHarness) post(path string) *http.Response {
+	h.mt.Helper()
+	resp, err := h.client.Post(h.app.URL+path, "application/json", nil)
+	if err != nil {
+		h.mt.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func (h *authHarness) me() (int, map[string]any) {
+	h.mt.Helper()
+	resp, err := h.client.Get(h.app.URL + "/auth/me")
+	if err != nil {
+		h.mt.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body map[string]any
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
+
+func (h *authHarness) cookie(path, name string) string {
+	u, _ := url.Parse(h.app.URL + path)
+	for _, c := range h.client.Jar.Cookies(u) {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// startedCommand descarta eventos hasta encontrar el comando pedido.
+func (h *authHarness) startedCommand(name string) *event.CommandStartedEvent {
+	h.mt.Helper()
+	for evt := h.mt.GetStartedEvent(); evt != nil; evt = h.mt.GetStartedEvent() {
+		if evt.CommandName == name {
+			return evt
+		}
+	}
+	h.mt.Fatalf("no se ejecutó %s", name)
+	return nil
+}
+
+// mockSession encola la respuesta de un FindOne con la sesión dada.
+func (h *authHarness) mockSession(doc bson.D) {
+	h.mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.sessions", mtest.FirstBatch, doc))
+}
+
+func mockUpdated(n int) bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "n", Value: n}, bson.E{Key: "nModified", Value: n})
+}
+
+func setField(doc bson.D, key string, value any) bson.D {
+	out := make(bson.D, 0, len(doc))
+	for _, e := range doc {
+		if e.Key != key {
+			out = append(out, e)
+		}
+	}
+	return append(out, bson.E{Key: key, Value: value})
+}
+
+func field(doc bson.D, key string) any {
+	for _, e := range doc {
+		if e.Key == key {
+			return e.Value
+		}
+	}
+	return nil
+}
+
+func TestDiscordLogin(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("crea la sesión y la cierra", func(mt *mtest.T) {
+		h := newAuthHarness(mt)
+		h.discord.addUser(alice, []string{"rol-moderador"})
+
+		sess := h.login(alice)
+		if field(sess, "userId") != alice.ID || field(sess, "role") != string(roleModerator) {
+			mt.Fatalf("sesión inesperada: %v", sess)
+		}
+
+		// El refresh token de Discord se guarda cifrado.
+		sealed, _ := field(sess, "discordRefreshToken").(string)
+		plain, err := h.srv.auth.openToken(sealed)
+		if err != nil || !strings.HasPrefix(plain, "refresh-"+alice.ID) || strings.Contains(sealed, plain) {
+			mt.Fatalf("refresh token de Discord mal guardado: %q → %q (%v)", sealed, plain, err)
+		}
+
+		if h.cookie("/", "auth_token") == "" || h.cookie("/auth/refresh", refreshCookieName) == "" {
+			mt.Fatal("faltan las cookies de sesión")
+		}
+		if h.cookie("/files", refreshCookieName) != "" {
+			mt.Fatal("el refresh token no debería enviarse fuera de /auth")
+		}
+
+		h.mockSession(sess)
+		status, me := h.me()
+		if status != http.StatusOK || me["username"] != alice.Username || me["role"] != string(roleModerator) {
+			mt.Fatalf("/auth/me = %d %v", status, me)
+		}
+
+		h.mt.AddMockResponses(mockUpdated(1))
+		if resp := h.post("/auth/logout"); resp.StatusCode != http.StatusOK {
+			mt.Fatalf("logout = %d", resp.StatusCode)
+		}
+		revoke := h.startedCommand("update")
+		if !strings.Contains(revoke.Command.String(), `"revokedReason": "logout"`) {
+			mt.Fatalf("logout no revocó la sesión: %s", revoke.Command)
+		}
+		if status, _ := h.me(); status != http.StatusUnauthorized {
+			mt.Fatalf("/auth/me después de logout = %d", status)
+		}
+	})
+
+	mt.Run("rechaza a quien no es miembro", func(mt *mtest.T) {
+		h := newAuthHarness(mt)
+		h.discord.addUser(bob, nil)
+		h.discord.login(bob.ID)
+
+		resp := h.get("/auth/discord")
+		if resp.StatusCode != http.StatusForbidden {
+			mt.Fatalf("status = %d, se esperaba 403", resp.StatusCode)
+		}
+		if h.cookie("/", "auth_token") != "" {
+			mt.Fatal("no debería crearse sesión")
+		}
+		if !h.srv.membership.rejects(bob.ID) {
+			mt.Fatal("el caché debería recordar que no es miembro")
+		}
+	})
+
+	mt.Run("vuelve al frontend si el usuario cancela", func(mt *mtest.T) {
+		h := newAuthHarness(mt)
+		h.discord.login("")
+
+		resp := h.get("/auth/discord")
+		if !strings.HasPrefix(resp.Request.URL.String(), h.frontend.URL) || resp.Request.URL.Query().Get("error") != "access_denied" {
+			mt.Fatalf("terminó en %s", resp.Request.URL)
+		}
+	})
+
+	mt.Run("rechaza un state distinto", func(mt *mtest.T) {
+		h := newAuthHarness(mt)
+		h.get("/auth/discord") // deja la cookie oauth_state
+
+		resp := h.get("/auth/discord/callback?code=x&state=otro")
+		if resp.StatusCode != http.StatusBadRequest {
+			mt.Fatalf("status = %d, se esperaba 400", resp.StatusCode)
+		}
+		if h.discord.called("POST /api/oauth2/token") {
+			mt.Fatal("no debería canjear el código")
+		}
+	})
+
+	mt.Run("rechaza un código que Discord no reconoce", func(mt *mtest.T) {
+		h := newAuthHarness(mt)
+		h.client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+		resp := h.get("/auth/discord")
+		state, _ := url.Parse(resp.Header.Get("Location"))
+
+		resp = h.get("/auth/discord/callback?code=inventado&state=" + url.QueryEscape(state.Query().Get("state")))
+		if resp.StatusCode != http.StatusUnauthorized {
+			mt.Fatalf("status = %d, se esperaba 401", resp.StatusCode)
+		}
+	})
+}
+
+func TestRefreshSession(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("rota el refresh token y actualiza el rol", func(mt *mtest.T) {
+		h := newAuthHarness(mt)
+		h.discord.addUser(alice, []string{"rol-moderador"})
+		sess := h.login(alice)
+		oldRefresh := h.cookie("/auth/refresh", refreshCookieName)
+
+		// Le sacan el rol en Discord y ya toca volver a consultar.
+		h.discord.addUser(alice, []string{})
+		sess = setField(sess, "discordCheckedAt", time.Now().Add(-2*time.Hour).UTC())
+		h.mockSession(sess)
+		h.mt.AddMockResponses(mockUpdated(1), mockUpdated(1), mockUpdated(1), mockUpdated(1), mockUpdated(1))
+
+		if resp := h.post("/auth/refresh"); resp.StatusCode != http.StatusOK {
+			mt.Fatalf("refresh = %d", resp.StatusCode)
+		}
+		capped, revoked := false, false
+		for _, e := range h.mt.GetAllStartedEvents() {
+			cmd := e.Command.String()
+			if e.CommandName == "update" && strings.Contains(cmd, `"$in"`) && strings.Contains(cmd, `"role": "listener"`) {
+				capped = true
+			}
+			// Las demás sesiones con el rol viejo se cierran; esta se renueva.
+			if e.CommandName == "update" && strings.Contains(cmd, `"role_lowered"`) && strings.Contains(cmd, `"$ne": "`+field(sess, "_id").(string)+`"`) {
+				revoked = true
+			}
+		}
+		if !capped {
+			mt.Fatal("los tokens de API no bajaron al rol nuevo")
+		}
+		if !revoked {
+			mt.Fatal("no se revocaron las otras sesiones con el rol viejo")
+		}
+		newRefresh := h.cookie("/auth/refresh", refreshCookieName)
+		if newRefresh == "" || newRefresh == oldRefresh {
+			mt.Fatal("el refresh token no rotó")
+		}
+		if !h.discord.called("GET /api/users/@me/guilds") {
+			mt.Fatal("no se volvió a verificar la membresía")
+		}
+
+		h.mockSession(sess)
+		if status, me := h.me(); status != http.StatusOK || me["role"] != string(roleListener) {
+			mt.Fatalf("/auth/me = %d %v", status, me)
+		}
+	})
+
+	mt.Run("reutilizar un refresh token cierra la sesión", func(mt *mtest.T) {
+		h := newAuthHarness(mt)
+		h.discord.addUser(alice, []string{})
+		sess := h.login(alice)
+		oldRefresh := h.cookie("/auth/refresh", refreshCookieName)
+
+		// La sesión ya va por la generación 1 desde hace rato.
+		sess = setField(sess, "refreshGeneration", 1)
+		sess = setField(sess, "rotatedAt", time.Now().Add(-time.Minute).UTC())
+		h.mockSession(sess)
+		h.mt.AddMockResponses(mockUpdated(1))
+
+		req, _ := http.NewRequest(http.MethodPost, h.app.URL+"/auth/refresh", nil)
+		req.AddCookie(&http.Cookie{Name: refreshCookieName, Value: oldRefresh})
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			mt.Fatalf("status = %d, se esperaba 401", resp.StatusCode)
+		}
+		revoke := h.startedCommand("update")
+		if !strings.Contains(revoke.Command.String(), `"revokedReason": "refresh_reuse"`) {
+			mt.Fatalf("no se revocó la familia: %s", revoke.Command)
+		}
+	})
+
+	mt.Run("cierra la sesión de quien dejó el servidor", func(mt *mtest.T) {
+		h := newAuthHarness(mt)
+		h.discord.addUser(alice, []string{})
+		sess := h.login(alice)
+
+		h.discord.leaveGuild(alice.ID, false)
+		sess = setField(sess, "discordCheckedAt", time.Now().Add(-2*time.Hour).UTC())
+		h.mockSession(sess)
+		h.mt.AddMockResponses(mockUpdated(1), mockUpdated(1), mockUpdated(1))
+
+		if resp := h.post("/auth/refresh"); resp.StatusCode != http.StatusForbidden {
+			mt.Fatalf("status = %d, se esperaba 403", resp.StatusCode)
+		}
+		if h.cookie("/auth/refresh", refreshCookieName) != "" {
+			mt.Fatal("debería borrarse el refresh token")
+		}
+		if !h.srv.membership.rejects(alice.ID) {
+			mt.Fatal("el caché debería recordar que ya no es miembro")
+		}
+	})
+
+	mt.Run("sin refresh token de Discord válido se cierra la sesión", func(mt *mtest.T) {
+		h := newAuthHarness(mt)
+		h.discord.addUser(alice, []string{})
+		sess := h.login(alice)
+
+		// Un refresh token que Discord ya no reconoce.
+		sealed, _ := h.srv.auth.sealToken("revocado")
+		sess = setField(sess, "discordRefreshToken", sealed)
+		sess = setField(sess, "discordCheckedAt", time.Now().Add(-2*time.Hour).UTC())
+		h.mockSession(sess)
+		h.mt.AddMockResponses(mockUpdated(1), mockUpdated(1))
+
+		if resp := h.post("/auth/refresh"); resp.StatusCode != http.StatusUnauthorized {
+			mt.Fatalf("status = %d, se esperaba 401", resp.StatusCode)
+		}
+	})
+}
+
+func TestGuildVerifier(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("revoca a los baneados y usa el caché", func(mt *mtest.T) {
+		h := newAuthHarness(mt)
+		h.discord.addUser(alice, []string{})
+		h.discord.addUser(bob, []string{})
+		h.discord.leaveGuild(bob.ID, true)
+
+		h.mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{alice.ID, bob.ID}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{bob.ID}}),
+			mockUpdated(1),
+			mockUpdated(0),
+			mockUpdated(2),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+		checked, removed, err := h.srv.verifyMembers(context.Background())
+		if err != nil || checked != 2 || removed != 1 {
+			mt.Fatalf("verifyMembers = %d, %d, %v", checked, removed, err)
+		}
+
+		// alice sigue, pero sin roles: sus tokens bajan a listener.
+		capped := h.startedCommand("update")
+		if !strings.Contains(capped.Command.String(), alice.ID) || !strings.Contains(capped.Command.String(), `"role": "listener"`) {
+			mt.Fatalf("no se ajustaron los tokens de alice: %s", capped.Command)
+		}
+		if sessions := h.startedCommand("update"); !strings.Contains(sessions.Command.String(), `"role_lowered"`) {
+			mt.Fatalf("no se revisaron las sesiones de alice: %s", sessions.Command)
+		}
+		update := h.startedCommand("update")
+		if !strings.Contains(update.Command.String(), `"revokedReason": "guild_banned"`) || !strings.Contains(update.Command.String(), bob.ID) {
+			mt.Fatalf("revocación inesperada: %s", update.Command)
+		}
+		h.startedCommand("delete")
+		if !h.srv.membership.rejects(bob.ID) || h.srv.membership.rejects(alice.ID) {
+			mt.Fatal("caché de membresía incorrecto")
+		}
+
+		// La segunda ronda no vuelve a consultar a Discord por alice.
+		h.discord.mu.Lock()
+		before := len(h.discord.requests)
+		h.discord.mu.Unlock()
+		h.mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{alice.ID}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{}}),
+		)
+		if _, removed, err := h.srv.verifyMembers(context.Background()); err != nil || removed != 0 {
+			mt.Fatalf("segunda ronda = %d, %v", removed, err)
+		}
+		h.discord.mu.Lock()
+		after := len(h.discord.requests)
+		h.discord.mu.Unlock()
+		if after != before {
+			mt.Fatalf("se hicieron %d consultas con el caché vigente", after-before)
+		}
+	})
+
+	mt.Run("no revoca nada si el bot no ve el servidor", func(mt *mtest.T) {
+		h := newAuthHarness(mt)
+		h.srv.auth.requiredGuildID = "servidor-sin-bot"
+
+		h.mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{alice.ID}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{}}),
+		)
+		_, removed, err := h.srv.verifyMembers(context.Background())
+		if err == nil || removed != 0 {
+			mt.Fatalf("verifyMembers = %d, %v; se esperaba un error sin revocaciones", removed, err)
+		}
+		if h.srv.membership.rejects(alice.ID) {
+			mt.Fatal("un error de Discord no debería marcar al usuario")
+		}
+	})
+
+	mt.Run("sigue con el resto si falla un usuario", func(mt *mtest.T) {
+		h := newAuthHarness(mt)
+		h.discord.addUser(alice, []string{})
+		h.discord.addUser(bob, []string{})
+		h.discord.failMember(alice.ID, http.StatusInternalServerError)
+		h.discord.leaveGuild(bob.ID, true)
+
+		h.mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{alice.ID, bob.ID}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{}}),
+			mockUpdated(1),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}),
+		)
+		checked, removed, err := h.srv.verifyMembers(context.Background())
+		if err == nil || checked != 1 || removed != 1 {
+			mt.Fatalf("verifyMembers = %d, %d, %v; se esperaba revocar a bob e informar el error", checked, removed, err)
+		}
+		if !h.srv.membership.rejects(bob.ID) || h.srv.membership.rejects(alice.ID) {
+			mt.Fatal("caché de membresía incorrecto")
+		}
+	})
+
+	mt.Run("corta la ronda si Discord pide esperar demasiado", func(mt *mtest.T) {
+		h := newAuthHarness(mt)
+		h.discord.addUser(alice, []string{})
+		h.discord.addUser(bob, []string{})
+		h.discord.failMember(alice.ID, http.StatusTooManyRequests)
+
+		h.mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{alice.ID, bob.ID}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{}}),
+		)
+		_, removed, err := h.srv.verifyMembers(context.Background())
+		var limited *rateLimitError
+		if !errors.As(err, &limited) || removed != 0 {
+			mt.Fatalf("verifyMembers = %d, %v; se esperaba cortar por el límite", removed, err)
+		}
+		if h.discord.called("GET /api/guilds/" + fakeGuildID + "/members/" + bob.ID) {
+			mt.Fatal("no debería consultar a más usuarios después del límite")
+		}
+	})
+
+	mt.Run("las consultas del bot respetan el contexto", func(mt *mtest.T) {
+		h := newAuthHarness(mt)
+		h.discord.addUser(alice, []string{})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, _, err := h.srv.auth.fetchMemberStatus(ctx, alice.ID); !errors.Is(err, context.Canceled) {
+			mt.Fatalf("fetchMemberStatus = %v, se esperaba context.Canceled", err)
+		}
+		if h.discord.called("GET /api/guilds/" + fakeGuildID + "/members/" + alice.ID) {
+			mt.Fatal("no debería consultar a Discord con el contexto cancelado")
+		}
+	})
+
+	mt.Run("cierra la sesión de quien pierde roles", func(mt *mtest.T) {
+		h := newAuthHarness(mt)
+		h.discord.addUser(alice, []string{"rol-moderador"})
+		sess := h.login(alice)
+		h.mockSession(sess)
+		if status, me := h.me(); status != http.StatusOK || me["role"] != string(roleModerator) {
+			mt.Fatalf("/auth/me = %d %v", status, me)
+		}
+
+		// Le sacan el rol en Discord y, vencido el caché, el verificador lo
+		// nota antes del próximo refresh.
+		h.discord.addUser(alice, []string{})
+		h.srv.membership = newMembershipCache(time.Minute)
+		h.mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{alice.ID}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{}}),
+			mockUpdated(0),
+			mockUpdated(1),
+		)
+		if _, removed, err := h.srv.verifyMembers(context.Background()); err != nil || removed != 0 {
+			mt.Fatalf("verifyMembers = %d, %v", removed, err)
+		}
+		h.startedCommand("update")
+		revoke := h.startedCommand("update")
+		cmd := revoke.Command.String()
+		if !strings.Contains(cmd, `"revokedReason": "role_lowered"`) || !strings.Contains(cmd, `"moderator"`) || strings.Contains(cmd, `"$ne"`) {
+			mt.Fatalf("revocación inesperada: %s", cmd)
+		}
+
+		// La cookie que ya tenía deja de servir aunque el JWT siga vigente.
+		h.mockSession(setField(sess, "revokedAt", time.Now().UTC()))
+		if status, _ := h.me(); status != http.StatusUnauthorized {
+			mt.Fatalf("/auth/me = %d, se esperaba 401", status)
+		}
+	})
+
+	mt.Run("rechaza la sesión si el caché dice que ya no es miembro", func(mt *mtest.T) {
+		h := newAuthHarness(mt)
+		h.discord.addUser(alice, []string{})
+		sess := h.login(alice)
+
+		h.srv.membership.set(alice.ID, memberLeft)
+		h.mockSession(sess)
+		if status, _ := h.me(); status != http.StatusForbidden {
+			mt.Fatalf("/auth/me = %d, se esperaba 403", status)
+		}
+	})
+}
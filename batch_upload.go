@@ -0,0 +1,313 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	batchQueued   = "queued"
+	batchConflict = "conflict"
+	batchLinked   = "linked"
+	batchRejected = "rejected"
+	batchSkipped  = "skipped"
+	batchFailed   = "error"
+)
+
+var zipMagic = []byte("PK\x03\x04")
+
+// batchResult es el resultado de un archivo dentro de una subida múltiple.
+// Archive indica el ZIP del que salió, si corresponde.
+type batchResult struct {
+	File        string            `json:"file"`
+	Archive     string            `json:"archive,omitempty"`
+	Name        string            `json:"name,omitempty"`
+	Status      string            `json:"status"`
+	JobID       string            `json:"jobId,omitempty"`
+	DuplicateOf string            `json:"duplicateOf,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	Violations  []policyViolation `json:"violations,omitempty"`
+}
+
+// batchBudget cuenta archivos y bytes de toda la petición, sueltos o
+// descomprimidos, para que un ZIP no pueda pasar los límites al expandirse.
+type batchBudget struct {
+	files int
+	bytes int64
+}
+
+// uploadBatch procesa varios campos 'file' y expande los ZIP. Cada archivo se
+// valida y encola por separado; uno rechazado no frena al resto.
+func (s *server) uploadBatch(w http.ResponseWriter, r *http.Request, claims *jwtClaims, parts []uploadPart) {
+	budget := &batchBudget{}
+	var results []batchResult
+	for _, part := range parts {
+		if part.Zip {
+			results = append(results, s.ingestZip(r.Context(), claims, part, budget)...)
+			continue
+		}
+		results = append(results, s.ingestBatchPart(r.Context(), claims, part, budget))
+	}
+
+	counts := make(map[string]int)
+	for _, res := range results {
+		counts[res.Status]++
+	}
+
+	log.Printf("subida múltiple: user_id=%s username=%s encolados=%d de %d", claims.UserID, claims.Username, counts[batchQueued], len(results))
+	writeJSON(w, http.StatusOK, map[string]any{
+		"results":   results,
+		"queued":    counts[batchQueued],
+		"conflicts": counts[batchConflict],
+		"linked":    counts[batchLinked],
+		"rejected":  counts[batchRejected],
+		"skipped":   counts[batchSkipped],
+		"failed":    counts[batchFailed],
+	})
+}
+
+func (s *server) takeBatchFile(budget *batchBudget) bool {
+	if budget.files >= s.uploadPolicy.MaxBatchFiles {
+		return false
+	}
+	budget.files++
+	return true
+}
+
+func (s *server) tooManyFiles(res batchResult) batchResult {
+	res.Status = batchFailed
+	res.Error = fmt.Sprintf("se superó el máximo de %d archivos por subida", s.uploadPolicy.MaxBatchFiles)
+	return res
+}
+
+func (s *server) ingestBatchPart(ctx context.Context, claims *jwtClaims, part uploadPart, budget *batchBudget) batchResult {
+	res := batchResult{File: part.FileName}
+	if !s.takeBatchFile(budget) {
+		return s.tooManyFiles(res)
+	}
+	if part.TooLarge {
+		return rejectedBatchResult(res, s.uploadPolicy.fileTooLarge(part.Size))
+	}
+
+	// Los archivos sueltos también gastan del total, así un ZIP posterior no
+	// puede expandirse a un MaxBatchSize entero además de ellos.
+	budget.bytes += part.Size
+	if budget.bytes > s.uploadPolicy.MaxBatchSize {
+		return rejectedBatchResult(res, s.uploadPolicy.batchTooLarge(budget.bytes))
+	}
+
+	return s.ingestBatchFile(ctx, claims, part.Path, part.Checksum, part.FileName)
+}
+
+// ingestZip expande las entradas de audio del ZIP. Las rutas nunca se usan
+// para escribir en disco: cada entrada se guarda en un temporal y su nombre
+// final sale de sanitizeName, pero las que intentan salir del archivo se
+// rechazan igual para que quede a la vista.
+func (s *server) ingestZip(ctx context.Context, claims *jwtClaims, part uploadPart, budget *batchBudget) []batchResult {
+	if part.TooLarge {
+		return []batchResult{rejectedBatchResult(batchResult{File: part.FileName}, s.uploadPolicy.batchTooLarge(part.Size))}
+	}
+
+	file, err := os.Open(part.Path)
+	if err != nil {
+		return []batchResult{{File: part.FileName, Status: batchFailed, Error: "no se pudo leer el archivo"}}
+	}
+	defer file.Close()
+
+	zr, err := zip.NewReader(file, part.Size)
+	if err != nil && !errors.Is(err, zip.ErrInsecurePath) {
+		return []batchResult{{File: part.FileName, Status: batchRejected, Error: "ZIP inválido"}}
+	}
+
+	var results []batchResult
+	for _, entry := range zr.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+
+		res := batchResult{File: entry.Name, Archive: part.FileName}
+		entryPath, ok := safeZipPath(entry.Name)
+		if !ok {
+			res.Status = batchRejected
+			res.Error = "ruta inválida dentro del ZIP"
+			results = append(results, res)
+			continue
+		}
+
+		// Metadatos que agregan algunos compresores, no vale la pena listarlos.
+		base := path.Base(entryPath)
+		if strings.HasPrefix(base, ".") || strings.HasPrefix(entryPath, "__MACOSX/") {
+			continue
+		}
+		if !s.allowsExtension(path.Ext(base)) {
+			res.Status = batchSkipped
+			res.Error = "no es un formato de audio aceptado"
+			results = append(results, res)
+			continue
+		}
+
+		if !s.takeBatchFile(budget) {
+			results = append(results, s.tooManyFiles(res))
+			break
+		}
+
+		res, stop := s.ingestZipEntry(ctx, claims, entry, base, res, budget)
+		results = append(results, res)
+		if stop {
+			break
+		}
+	}
+
+	if len(results) == 0 {
+		return []batchResult{{File: part.FileName, Status: batchRejected, Error: "el ZIP no contiene audios"}}
+	}
+	return results
+}
+
+// ingestZipEntry descomprime una entrada cortando en el límite por archivo y
+// en lo que queda del total de la petición, sin confiar en el tamaño que
+// declara el ZIP. Devuelve stop cuando ya no queda presupuesto.
+func (s *server) ingestZipEntry(ctx context.Context, claims *jwtClaims, entry *zip.File, base string, res batchResult, budget *batchBudget) (batchResult, bool) {
+	perFile := s.uploadPolicy.MaxFileSize
+	remaining := s.uploadPolicy.MaxBatchSize - budget.bytes
+
+	if entry.UncompressedSize64 > uint64(perFile) {
+		return rejectedBatchResult(res, s.uploadPolicy.fileTooLarge(int64(entry.UncompressedSize64))), false
+	}
+	if entry.UncompressedSize64 > uint64(remaining) {
+		return rejectedBatchResult(res, s.uploadPolicy.batchTooLarge(budget.bytes+int64(entry.UncompressedSize64))), true
+	}
+
+	rc, err := entry.Open()
+	if err != nil {
+		res.Status = batchRejected
+		res.Error = "entrada del ZIP ilegible"
+		return res, false
+	}
+	spoolPath, checksum, err := s.spoolUpload(io.LimitReader(rc, min(perFile, remaining)+1))
+	rc.Close()
+	if err != nil {
+		var corrupt flate.CorruptInputError
+		if errors.Is(err, zip.ErrChecksum) || errors.Is(err, zip.ErrFormat) || errors.Is(err, zip.ErrAlgorithm) || errors.As(err, &corrupt) {
+			res.Status = batchRejected
+			res.Error = "entrada del ZIP dañada"
+			return res, false
+		}
+		log.Printf("error al extraer %s: %v", entry.Name, err)
+		res.Status = batchFailed
+		res.Error = "no se pudo guardar el archivo"
+		return res, false
+	}
+	defer os.Remove(spoolPath)
+
+	stat, err := os.Stat(spoolPath)
+	if err != nil {
+		res.Status = batchFailed
+		res.Error = "no se pudo guardar el archivo"
+		return res, false
+	}
+	budget.bytes += stat.Size()
+	if stat.Size() > perFile {
+		return rejectedBatchResult(res, s.uploadPolicy.fileTooLarge(stat.Size())), false
+	}
+	if stat.Size() > remaining {
+		return rejectedBatchResult(res, s.uploadPolicy.batchTooLarge(budget.bytes)), true
+	}
+
+	out := s.ingestBatchFile(ctx, claims, spoolPath, checksum, base)
+	out.File, out.Archive = res.File, res.Archive
+	return out, false
+}
+
+func (s *server) ingestBatchFile(ctx context.Context, claims *jwtClaims, spoolPath, checksum, fileName string) batchResult {
+	res := batchResult{File: fileName}
+
+	safeName, err := sanitizeName(fileName)
+	if err != nil {
+		res.Status = batchRejected
+		res.Error = err.Error()
+		return res
+	}
+	res.Name = ensureMP3Name(safeName)
+
+	out, err := s.ingestUpload(ctx, ingestRequest{
+		SourcePath:     spoolPath,
+		SourceChecksum: checksum,
+		OriginalName:   fileName,
+		TargetName:     res.Name,
+		Claims:         claims,
+	})
+	if err != nil {
+		return s.failedBatchResult(res, err)
+	}
+	if out.DuplicateOf != "" {
+		res.Status = batchLinked
+		res.DuplicateOf = out.DuplicateOf
+		return res
+	}
+
+	res.Status = batchQueued
+	res.JobID = out.JobID
+	return res
+}
+
+func rejectedBatchResult(res batchResult, err *policyError) batchResult {
+	res.Status = batchRejected
+	res.Error = err.Error()
+	res.Violations = err.violations
+	return res
+}
+
+// failedBatchResult traduce los mismos errores que writeIngestError a un
+// resultado por archivo.
+func (s *server) failedBatchResult(res batchResult, err error) batchResult {
+	var rejected *policyError
+	var duplicate *duplicateError
+	switch {
+	case errors.Is(err, errSoundExists):
+		res.Status = batchConflict
+		res.Error = "ya existe un archivo con ese nombre"
+	case errors.As(err, &duplicate):
+		res.Status = batchConflict
+		res.Error = duplicate.Error()
+		res.DuplicateOf = duplicate.Existing
+	case errors.As(err, &rejected):
+		return rejectedBatchResult(res, rejected)
+	case errors.Is(err, errUnsupportedFormat):
+		res.Status = batchRejected
+		res.Error = "formato no soportado, usa " + s.allowedFormatNames()
+	case errors.Is(err, errUnreadableAudio):
+		res.Status = batchRejected
+		res.Error = "el archivo no es un audio válido"
+	case errors.Is(err, errQueueFull):
+		res.Status = batchFailed
+		res.Error = "hay demasiadas conversiones pendientes, intenta más tarde"
+	default:
+		log.Printf("error al procesar subida %s: %v", res.File, err)
+		res.Status = batchFailed
+		res.Error = "no se pudo procesar el archivo"
+	}
+	return res
+}
+
+// safeZipPath rechaza rutas absolutas o que suben de carpeta (zip slip).
+func safeZipPath(name string) (string, bool) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if path.IsAbs(name) || (len(name) > 1 && name[1] == ':') {
+		return "", false
+	}
+	clean := path.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", false
+	}
+	return clean, true
+}
@@ -4,8 +4,11 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type appConfig struct {
@@ -15,6 +18,34 @@ type appConfig struct {
 	AllowedOrigins []string
 	Auth           authConfig
 	Mongo          mongoConfig
+	Storage        storageConfig
+	Trash          trashConfig
+	Upload         uploadPolicy
+	Loudnorm       loudnormConfig
+	Jobs           jobsConfig
+	FFmpeg         ffmpegConfig
+	Formats        []audioFormat
+	URLImport      urlImportConfig
+}
+
+type jobsConfig struct {
+	DataDir       string
+	Workers       int
+	QueueSize     int
+	Timeout       time.Duration
+	TusExpiration time.Duration
+	InstanceID    string
+	LeaseTTL      time.Duration
+}
+
+type loudnormConfig struct {
+	Enabled bool
+	Target  loudnormTarget
+}
+
+type trashConfig struct {
+	Retention     time.Duration
+	PurgeInterval time.Duration
 }
 
 type authConfig struct {
@@ -23,12 +54,48 @@ type authConfig struct {
 	RedirectURI     string
 	JWTSecret       string
 	RequiredGuildID string
+	AdminUserIDs    []string
+	RoleIDs         map[wasabiRole][]string
+	DefaultRole     wasabiRole
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	RecheckInterval time.Duration
+	BotToken        string
+	VerifyInterval  time.Duration
+	VerifyCacheTTL  time.Duration
+
+	// DiscordBaseURL y HTTPClient permiten apuntar el login a otro servidor,
+	// como el Discord falso de las pruebas. Vacíos usan discord.com y un
+	// cliente con timeout.
+	DiscordBaseURL string
+	HTTPClient     *http.Client
+}
+
+type storageConfig struct {
+	Backend  string
+	LocalDir string
+	S3       s3Config
+}
+
+type s3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Prefix    string
+	PathStyle bool
 }
 
 type mongoConfig struct {
-	URI        string
-	Database   string
-	Collection string
+	URI                string
+	Database           string
+	Collection         string
+	SoundsCollection   string
+	TrashCollection    string
+	JobsCollection     string
+	TokensCollection   string
+	SessionsCollection string
 }
 
 func loadAppConfig() (appConfig, error) {
@@ -62,6 +129,46 @@ func loadAppConfig() (appConfig, error) {
 		return appConfig{}, err
 	}
 
+	storageCfg, err := readStorageConfig(upload)
+	if err != nil {
+		return appConfig{}, err
+	}
+
+	trashCfg, err := readTrashConfig()
+	if err != nil {
+		return appConfig{}, err
+	}
+
+	uploadCfg, err := readUploadPolicy()
+	if err != nil {
+		return appConfig{}, err
+	}
+
+	loudnormCfg, err := readLoudnormConfig()
+	if err != nil {
+		return appConfig{}, err
+	}
+
+	jobsCfg, err := readJobsConfig()
+	if err != nil {
+		return appConfig{}, err
+	}
+
+	ffmpegCfg, err := readFFmpegConfig()
+	if err != nil {
+		return appConfig{}, err
+	}
+
+	formats, err := readAllowedFormats()
+	if err != nil {
+		return appConfig{}, err
+	}
+
+	urlImportCfg, err := readURLImportConfig(uploadCfg)
+	if err != nil {
+		return appConfig{}, err
+	}
+
 	return appConfig{
 		Addr:           addr,
 		UploadDir:      upload,
@@ -69,6 +176,14 @@ func loadAppConfig() (appConfig, error) {
 		AllowedOrigins: mergeOrigins(frontendOrigins, splitOrigins(os.Getenv("ALLOWED_ORIGINS"))),
 		Auth:           authCfg,
 		Mongo:          mongoCfg,
+		Storage:        storageCfg,
+		Trash:          trashCfg,
+		Upload:         uploadCfg,
+		Loudnorm:       loudnormCfg,
+		Jobs:           jobsCfg,
+		FFmpeg:         ffmpegCfg,
+		Formats:        formats,
+		URLImport:      urlImportCfg,
 	}, nil
 }
 
@@ -90,6 +205,16 @@ func splitOrigins(raw string) []string {
 	return origins
 }
 
+func splitList(raw string) []string {
+	var items []string
+	for _, part := range strings.Split(raw, ",") {
+		if item := strings.TrimSpace(part); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func mergeOrigins(groups ...[]string) []string {
 	seen := make(map[string]struct{})
 	var merged []string
@@ -139,12 +264,66 @@ func readAuthConfig() (authConfig, error) {
 		return authConfig{}, fmt.Errorf("DISCORD_REQUIRED_GUILD_ID es requerido")
 	}
 
+	roleIDs := map[wasabiRole][]string{
+		roleAdmin:     splitList(os.Getenv("DISCORD_ADMIN_ROLE_IDS")),
+		roleModerator: splitList(os.Getenv("DISCORD_MODERATOR_ROLE_IDS")),
+		roleUploader:  splitList(os.Getenv("DISCORD_UPLOADER_ROLE_IDS")),
+		roleListener:  splitList(os.Getenv("DISCORD_LISTENER_ROLE_IDS")),
+	}
+
+	// Sin roles de uploader configurados, todo miembro puede subir como antes.
+	defaultRole := roleUploader
+	if len(roleIDs[roleUploader]) > 0 {
+		defaultRole = roleListener
+	}
+	if raw := strings.TrimSpace(os.Getenv("DEFAULT_ROLE")); raw != "" {
+		role, err := parseRole(raw)
+		if err != nil {
+			return authConfig{}, fmt.Errorf("DEFAULT_ROLE inválido: %w", err)
+		}
+		defaultRole = role
+	}
+
+	accessTTL, err := durationEnv("ACCESS_TOKEN_TTL", 15*time.Minute)
+	if err != nil {
+		return authConfig{}, err
+	}
+	refreshTTL, err := durationEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+	if err != nil {
+		return authConfig{}, err
+	}
+	if refreshTTL < accessTTL {
+		return authConfig{}, fmt.Errorf("REFRESH_TOKEN_TTL no puede ser menor que ACCESS_TOKEN_TTL")
+	}
+	recheck, err := durationEnv("DISCORD_RECHECK_INTERVAL", time.Hour)
+	if err != nil {
+		return authConfig{}, err
+	}
+	verifyInterval, err := durationEnv("GUILD_VERIFY_INTERVAL", 5*time.Minute)
+	if err != nil {
+		return authConfig{}, err
+	}
+	verifyCacheTTL, err := durationEnv("GUILD_VERIFY_CACHE_TTL", 30*time.Minute)
+	if err != nil {
+		return authConfig{}, err
+	}
+
 	return authConfig{
 		ClientID:        clientID,
 		ClientSecret:    clientSecret,
 		RedirectURI:     redirectURI,
 		JWTSecret:       secret,
 		RequiredGuildID: requiredGuildID,
+		AdminUserIDs:    splitList(os.Getenv("DISCORD_ADMIN_USER_IDS")),
+		RoleIDs:         roleIDs,
+		DefaultRole:     defaultRole,
+		AccessTokenTTL:  accessTTL,
+		RefreshTokenTTL: refreshTTL,
+		RecheckInterval: recheck,
+		BotToken:        strings.TrimSpace(os.Getenv("DISCORD_BOT_TOKEN")),
+		VerifyInterval:  verifyInterval,
+		VerifyCacheTTL:  verifyCacheTTL,
+		DiscordBaseURL:  strings.TrimSpace(os.Getenv("DISCORD_BASE_URL")),
 	}, nil
 }
 
@@ -164,13 +343,374 @@ func readMongoConfig() (mongoConfig, error) {
 		collection = "intros"
 	}
 
+	soundsCollection := strings.TrimSpace(os.Getenv("MONGO_SOUNDS_COLLECTION"))
+	if soundsCollection == "" {
+		soundsCollection = "sounds"
+	}
+
+	trashCollection := strings.TrimSpace(os.Getenv("MONGO_TRASH_COLLECTION"))
+	if trashCollection == "" {
+		trashCollection = "trash"
+	}
+
+	jobsCollection := strings.TrimSpace(os.Getenv("MONGO_JOBS_COLLECTION"))
+	if jobsCollection == "" {
+		jobsCollection = "jobs"
+	}
+
+	tokensCollection := strings.TrimSpace(os.Getenv("MONGO_TOKENS_COLLECTION"))
+	if tokensCollection == "" {
+		tokensCollection = "api_tokens"
+	}
+
+	sessionsCollection := strings.TrimSpace(os.Getenv("MONGO_SESSIONS_COLLECTION"))
+	if sessionsCollection == "" {
+		sessionsCollection = "sessions"
+	}
+
 	return mongoConfig{
-		URI:        uri,
-		Database:   db,
-		Collection: collection,
+		URI:                uri,
+		Database:           db,
+		Collection:         collection,
+		SoundsCollection:   soundsCollection,
+		TrashCollection:    trashCollection,
+		JobsCollection:     jobsCollection,
+		TokensCollection:   tokensCollection,
+		SessionsCollection: sessionsCollection,
+	}, nil
+}
+
+func readStorageConfig(uploadDir string) (storageConfig, error) {
+	backend := strings.ToLower(strings.TrimSpace(os.Getenv("STORAGE_BACKEND")))
+	if backend == "" {
+		backend = "local"
+	}
+
+	switch backend {
+	case "local":
+		return storageConfig{Backend: backend, LocalDir: uploadDir}, nil
+	case "s3":
+	default:
+		return storageConfig{}, fmt.Errorf("STORAGE_BACKEND debe ser local o s3")
+	}
+
+	endpoint := strings.TrimSpace(os.Getenv("S3_ENDPOINT"))
+	if endpoint == "" {
+		endpoint = "https://s3.amazonaws.com"
+	}
+
+	region := strings.TrimSpace(os.Getenv("S3_REGION"))
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	bucket := strings.TrimSpace(os.Getenv("S3_BUCKET"))
+	accessKey := strings.TrimSpace(os.Getenv("S3_ACCESS_KEY"))
+	secretKey := strings.TrimSpace(os.Getenv("S3_SECRET_KEY"))
+	if bucket == "" {
+		return storageConfig{}, fmt.Errorf("S3_BUCKET es requerido")
+	}
+	if accessKey == "" || secretKey == "" {
+		return storageConfig{}, fmt.Errorf("S3_ACCESS_KEY y S3_SECRET_KEY son requeridos")
+	}
+
+	prefix := strings.Trim(strings.TrimSpace(os.Getenv("S3_PREFIX")), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	pathStyle := true
+	if raw := strings.TrimSpace(os.Getenv("S3_PATH_STYLE")); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return storageConfig{}, fmt.Errorf("S3_PATH_STYLE inválido: %w", err)
+		}
+		pathStyle = parsed
+	}
+
+	return storageConfig{
+		Backend:  backend,
+		LocalDir: uploadDir,
+		S3: s3Config{
+			Endpoint:  endpoint,
+			Region:    region,
+			Bucket:    bucket,
+			AccessKey: accessKey,
+			SecretKey: secretKey,
+			Prefix:    prefix,
+			PathStyle: pathStyle,
+		},
+	}, nil
+}
+
+func readTrashConfig() (trashConfig, error) {
+	retention, err := durationEnv("TRASH_RETENTION", 30*24*time.Hour)
+	if err != nil {
+		return trashConfig{}, err
+	}
+
+	interval, err := durationEnv("TRASH_PURGE_INTERVAL", time.Hour)
+	if err != nil {
+		return trashConfig{}, err
+	}
+
+	return trashConfig{Retention: retention, PurgeInterval: interval}, nil
+}
+
+func readUploadPolicy() (uploadPolicy, error) {
+	policy := uploadPolicy{MaxFileSize: 32 << 20, MaxBatchSize: 512 << 20}
+
+	if raw := strings.TrimSpace(os.Getenv("UPLOAD_MAX_FILE_SIZE")); raw != "" {
+		size, err := parseByteSize(raw)
+		if err != nil {
+			return uploadPolicy{}, fmt.Errorf("UPLOAD_MAX_FILE_SIZE inválido (ej: 32MB): %w", err)
+		}
+		policy.MaxFileSize = size
+	}
+
+	if strings.TrimSpace(os.Getenv("UPLOAD_MAX_DURATION")) != "" {
+		d, err := durationEnv("UPLOAD_MAX_DURATION", 0)
+		if err != nil {
+			return uploadPolicy{}, err
+		}
+		policy.MaxDuration = d
+	}
+
+	for _, raw := range splitList(os.Getenv("UPLOAD_ALLOWED_SAMPLE_RATES")) {
+		rate, err := strconv.Atoi(raw)
+		if err != nil || rate <= 0 {
+			return uploadPolicy{}, fmt.Errorf("UPLOAD_ALLOWED_SAMPLE_RATES contiene un valor inválido: %q", raw)
+		}
+		policy.AllowedSampleRates = append(policy.AllowedSampleRates, rate)
+	}
+
+	if raw := strings.TrimSpace(os.Getenv("UPLOAD_BATCH_MAX_SIZE")); raw != "" {
+		size, err := parseByteSize(raw)
+		if err != nil {
+			return uploadPolicy{}, fmt.Errorf("UPLOAD_BATCH_MAX_SIZE inválido (ej: 512MB): %w", err)
+		}
+		policy.MaxBatchSize = size
+	}
+
+	policy.Duplicates = strings.ToLower(strings.TrimSpace(os.Getenv("DUPLICATE_POLICY")))
+	switch policy.Duplicates {
+	case "":
+		policy.Duplicates = duplicatesReject
+	case duplicatesReject, duplicatesLink:
+	default:
+		return uploadPolicy{}, fmt.Errorf("DUPLICATE_POLICY debe ser reject o link")
+	}
+
+	var err error
+	if policy.MaxBatchFiles, err = positiveIntEnv("UPLOAD_BATCH_MAX_FILES", 50); err != nil {
+		return uploadPolicy{}, err
+	}
+	if policy.MaxLoudnessLUFS, err = optionalFloatEnv("UPLOAD_MAX_LOUDNESS_LUFS"); err != nil {
+		return uploadPolicy{}, err
+	}
+	if policy.MaxTruePeak, err = optionalFloatEnv("UPLOAD_MAX_TRUE_PEAK"); err != nil {
+		return uploadPolicy{}, err
+	}
+
+	return policy, nil
+}
+
+func readLoudnormConfig() (loudnormConfig, error) {
+	cfg := loudnormConfig{Target: loudnormTarget{IntegratedLUFS: -16, TruePeak: -1.5, LRA: 11}}
+
+	if raw := strings.TrimSpace(os.Getenv("LOUDNORM_ENABLED")); raw != "" {
+		enabled, err := strconv.ParseBool(raw)
+		if err != nil {
+			return loudnormConfig{}, fmt.Errorf("LOUDNORM_ENABLED inválido: %w", err)
+		}
+		cfg.Enabled = enabled
+	}
+
+	for key, dst := range map[string]*float64{
+		"LOUDNORM_TARGET_LUFS": &cfg.Target.IntegratedLUFS,
+		"LOUDNORM_TARGET_TP":   &cfg.Target.TruePeak,
+		"LOUDNORM_TARGET_LRA":  &cfg.Target.LRA,
+	} {
+		v, err := optionalFloatEnv(key)
+		if err != nil {
+			return loudnormConfig{}, err
+		}
+		if v != nil {
+			*dst = *v
+		}
+	}
+
+	return cfg, nil
+}
+
+func readJobsConfig() (jobsConfig, error) {
+	dataDir := strings.TrimSpace(os.Getenv("DATA_DIR"))
+	if dataDir == "" {
+		dataDir = "data"
+	}
+
+	workers, err := positiveIntEnv("TRANSCODE_WORKERS", 2)
+	if err != nil {
+		return jobsConfig{}, err
+	}
+
+	queueSize, err := positiveIntEnv("TRANSCODE_QUEUE_SIZE", 64)
+	if err != nil {
+		return jobsConfig{}, err
+	}
+
+	timeout, err := durationEnv("TRANSCODE_JOB_TIMEOUT", 15*time.Minute)
+	if err != nil {
+		return jobsConfig{}, err
+	}
+
+	tusExpiration, err := durationEnv("TUS_EXPIRATION", 24*time.Hour)
+	if err != nil {
+		return jobsConfig{}, err
+	}
+
+	// Los archivos de entrada viven en el DATA_DIR de cada instancia, así que
+	// cada una retoma solo sus trabajos. El ID debe sobrevivir a reinicios.
+	instanceID := strings.TrimSpace(os.Getenv("JOB_INSTANCE_ID"))
+	if instanceID == "" {
+		instanceID, err = os.Hostname()
+		if err != nil || instanceID == "" {
+			return jobsConfig{}, fmt.Errorf("no se pudo obtener el hostname, define JOB_INSTANCE_ID")
+		}
+	}
+
+	leaseTTL, err := durationEnv("JOB_LEASE_TTL", 2*time.Minute)
+	if err != nil {
+		return jobsConfig{}, err
+	}
+	if leaseTTL < 10*time.Second {
+		return jobsConfig{}, fmt.Errorf("JOB_LEASE_TTL debe ser de al menos 10s")
+	}
+
+	return jobsConfig{
+		DataDir:       dataDir,
+		Workers:       workers,
+		QueueSize:     queueSize,
+		Timeout:       timeout,
+		TusExpiration: tusExpiration,
+		InstanceID:    instanceID,
+		LeaseTTL:      leaseTTL,
 	}, nil
 }
 
+func readFFmpegConfig() (ffmpegConfig, error) {
+	cfg := ffmpegConfig{MaxOutputSize: 100 << 20}
+
+	var err error
+	if cfg.Timeout, err = durationEnv("FFMPEG_TIMEOUT", 5*time.Minute); err != nil {
+		return ffmpegConfig{}, err
+	}
+	if cfg.ProbeTimeout, err = durationEnv("FFPROBE_TIMEOUT", 30*time.Second); err != nil {
+		return ffmpegConfig{}, err
+	}
+
+	if strings.TrimSpace(os.Getenv("FFMPEG_CPU_LIMIT")) != "" {
+		if cfg.CPULimit, err = durationEnv("FFMPEG_CPU_LIMIT", 0); err != nil {
+			return ffmpegConfig{}, err
+		}
+		if cfg.CPULimit < time.Second {
+			return ffmpegConfig{}, fmt.Errorf("FFMPEG_CPU_LIMIT debe ser de al menos 1s")
+		}
+	}
+
+	if raw := strings.TrimSpace(os.Getenv("FFMPEG_MAX_OUTPUT_SIZE")); raw != "" {
+		size, err := parseByteSize(raw)
+		if err != nil {
+			return ffmpegConfig{}, fmt.Errorf("FFMPEG_MAX_OUTPUT_SIZE inválido (ej: 100MB): %w", err)
+		}
+		cfg.MaxOutputSize = size
+	}
+
+	return cfg, nil
+}
+
+func readAllowedFormats() ([]audioFormat, error) {
+	names := splitList(strings.ToLower(os.Getenv("ALLOWED_FORMATS")))
+	if len(names) == 0 {
+		return knownFormats, nil
+	}
+
+	formats := make([]audioFormat, 0, len(names))
+	for _, name := range names {
+		format, ok := formatByName(name)
+		if !ok {
+			return nil, fmt.Errorf("ALLOWED_FORMATS contiene un formato desconocido: %q", name)
+		}
+		formats = append(formats, format)
+	}
+	return formats, nil
+}
+
+func readURLImportConfig(upload uploadPolicy) (urlImportConfig, error) {
+	cfg := urlImportConfig{MaxSize: upload.MaxFileSize}
+
+	var err error
+	if cfg.Timeout, err = durationEnv("URL_IMPORT_TIMEOUT", time.Minute); err != nil {
+		return urlImportConfig{}, err
+	}
+
+	if raw := strings.TrimSpace(os.Getenv("URL_IMPORT_MAX_SIZE")); raw != "" {
+		if cfg.MaxSize, err = parseByteSize(raw); err != nil {
+			return urlImportConfig{}, fmt.Errorf("URL_IMPORT_MAX_SIZE inválido (ej: 20MB): %w", err)
+		}
+	}
+
+	if cfg.AllowCIDRs, err = parseCIDRs(splitList(os.Getenv("URL_IMPORT_ALLOW_CIDRS"))); err != nil {
+		return urlImportConfig{}, fmt.Errorf("URL_IMPORT_ALLOW_CIDRS: %w", err)
+	}
+	if cfg.DenyCIDRs, err = parseCIDRs(splitList(os.Getenv("URL_IMPORT_DENY_CIDRS"))); err != nil {
+		return urlImportConfig{}, fmt.Errorf("URL_IMPORT_DENY_CIDRS: %w", err)
+	}
+
+	cfg.ContentTypes = splitList(strings.ToLower(os.Getenv("URL_IMPORT_CONTENT_TYPES")))
+	if len(cfg.ContentTypes) == 0 {
+		cfg.ContentTypes = []string{"audio/*", "video/*", "application/ogg", "application/octet-stream"}
+	}
+
+	return cfg, nil
+}
+
+func positiveIntEnv(key string, fallback int) (int, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return fallback, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("%s debe ser un entero positivo", key)
+	}
+	return v, nil
+}
+
+func optionalFloatEnv(key string) (*float64, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s debe ser un número: %w", key, err)
+	}
+	return &v, nil
+}
+
+func durationEnv(key string, fallback time.Duration) (time.Duration, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s debe ser una duración positiva (ej: 720h)", key)
+	}
+	return d, nil
+}
+
 func loadDotEnv(path string) error {
 	f, err := os.Open(path)
 	if err != nil {
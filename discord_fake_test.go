@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	fakeClientID     = "fake-client"
+	fakeClientSecret = "fake-secret"
+	fakeBotToken     = "fake-bot"
+	fakeGuildID      = "guild-1"
+)
+
+// fakeDiscord imita los endpoints de OAuth2 y de la API de Discord que usa
+// el login: autorización, canje y renovación de tokens, perfil, servidores,
+// roles del miembro, y las consultas del bot sobre miembros y baneos.
+type fakeDiscord struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	users     map[string]discordUser
+	members   map[string][]string // userID → roles en fakeGuildID
+	bans      map[string]bool
+	failing   map[string]int // userID → estado con que falla la consulta del bot
+	loginAs   string
+	codes     map[string]string // code → userID
+	access    map[string]string // access token → userID
+	refresh   map[string]string // refresh token → userID
+	nextToken int
+	requests  []string
+}
+
+func newFakeDiscord(t *testing.T) *fakeDiscord {
+	t.Helper()
+
+	f := &fakeDiscord{
+		users:   make(map[string]discordUser),
+		members: make(map[string][]string),
+		bans:    make(map[string]bool),
+		failing: make(map[string]int),
+		codes:   make(map[string]string),
+		access:  make(map[string]string),
+		refresh: make(map[string]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /oauth2/authorize", f.authorize)
+	mux.HandleFunc("POST /api/oauth2/token", f.token)
+	mux.HandleFunc("GET /api/users/@me", f.withUser(f.me))
+	mux.HandleFunc("GET /api/users/@me/guilds", f.withUser(f.guilds))
+	mux.HandleFunc("GET /api/users/@me/guilds/{guild}/member", f.withUser(f.member))
+	mux.HandleFunc("GET /api/guilds/{guild}/members/{user}", f.withBot(f.botMember))
+	mux.HandleFunc("GET /api/guilds/{guild}/bans/{user}", f.withBot(f.botBan))
+
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+		f.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// addUser registra un usuario; con roles distinto de nil además es miembro
+// del servidor.
+func (f *fakeDiscord) addUser(user discordUser, roles []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users[user.ID] = user
+	if roles != nil {
+		f.members[user.ID] = roles
+	}
+}
+
+// login elige qué usuario aprueba la próxima autorización; vacío la rechaza.
+func (f *fakeDiscord) login(userID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.loginAs = userID
+}
+
+func (f *fakeDiscord) leaveGuild(userID string, banned bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.members, userID)
+	f.bans[userID] = banned
+}
+
+// failMember hace que la consulta del bot por userID responda status; con
+// 429 pide esperar más de lo que el verificador está dispuesto a esperar.
+func (f *fakeDiscord) failMember(userID string, status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failing[userID] = status
+}
+
+// issueRefreshToken entrega un refresh token válido sin pasar por el login.
+func (f *fakeDiscord) issueRefreshToken(userID string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.newToken(f.refresh, "refresh", userID)
+}
+
+func (f *fakeDiscord) called(request string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range f.requests {
+		if r == request {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeDiscord) newToken(store map[string]string, kind, userID string) string {
+	f.nextToken++
+	token := fmt.Sprintf("%s-%s-%d", kind, userID, f.nextToken)
+	store[token] = userID
+	return token
+}
+
+func (f *fakeDiscord) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != fakeClientID || q.Get("response_type") != "code" {
+		http.Error(w, "invalid client", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirect.Host == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	callback := url.Values{"state": {q.Get("state")}}
+	if f.loginAs == "" {
+		callback.Set("error", "access_denied")
+	} else {
+		callback.Set("code", f.newToken(f.codes, "code", f.loginAs))
+	}
+	f.mu.Unlock()
+
+	redirect.RawQuery = callback.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (f *fakeDiscord) token(w http.ResponseWriter, r *http.Request) {
+	if r.PostFormValue("client_id") != fakeClientID || r.PostFormValue("client_secret") != fakeClientSecret {
+		writeDiscordError(w, http.StatusUnauthorized, 0, "invalid_client")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var userID string
+	var ok bool
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		userID, ok = f.codes[r.PostFormValue("code")]
+		delete(f.codes, r.PostFormValue("code"))
+	case "refresh_token":
+		// Igual que Discord, cada refresh token sirve una sola vez.
+		userID, ok = f.refresh[r.PostFormValue("refresh_token")]
+		delete(f.refresh, r.PostFormValue("refresh_token"))
+	}
+	if !ok {
+		writeDiscordError(w, http.StatusBadRequest, 0, "invalid_grant")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, discordToken{
+		AccessToken:  f.newToken(f.access, "access", userID),
+		TokenType:    "Bearer",
+		RefreshToken: f.newToken(f.refresh, "refresh", userID),
+		ExpiresIn:    604800,
+	})
+}
+
+func (f *fakeDiscord) withUser(next func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		f.mu.Lock()
+		userID, ok := f.access[token]
+		f.mu.Unlock()
+		if !ok {
+			writeDiscordError(w, http.StatusUnauthorized, 0, "401: Unauthorized")
+			return
+		}
+		next(w, r, userID)
+	}
+}
+
+func (f *fakeDiscord) withBot(next func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bot "+fakeBotToken {
+			writeDiscordError(w, http.StatusUnauthorized, 0, "401: Unauthorized")
+			return
+		}
+		if r.PathValue("guild") != fakeGuildID {
+			writeDiscordError(w, http.StatusNotFound, 10004, "Unknown Guild")
+			return
+		}
+		next(w, r, r.PathValue("user"))
+	}
+}
+
+func (f *fakeDiscord) me(w http.ResponseWriter, _ *http.Request, userID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	writeJSON(w, http.StatusOK, f.users[userID])
+}
+
+func (f *fakeDiscord) guilds(w http.ResponseWriter, _ *http.Request, userID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	guilds := []map[string]string{{"id": "otro-servidor"}}
+	if _, ok := f.members[userID]; ok {
+		guilds = append(guilds, map[string]string{"id": fakeGuildID})
+	}
+	writeJSON(w, http.StatusOK, guilds)
+}
+
+func (f *fakeDiscord) member(w http.ResponseWriter, r *http.Request, userID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	roles, ok := f.members[userID]
+	if !ok || r.PathValue("guild") != fakeGuildID {
+		writeDiscordError(w, http.StatusNotFound, 10004, "Unknown Guild")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"roles": roles})
+}
+
+func (f *fakeDiscord) botMember(w http.ResponseWriter, _ *http.Request, userID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if status, ok := f.failing[userID]; ok {
+		if status == http.StatusTooManyRequests {
+			writeJSON(w, status, map[string]any{"message": "You are being rate limited.", "retry_after": 3600})
+			return
+		}
+		writeDiscordError(w, status, 0, "Internal Server Error")
+		return
+	}
+	roles, ok := f.members[userID]
+	if !ok {
+		writeDiscordError(w, http.StatusNotFound, 10007, "Unknown Member")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"user": f.users[userID], "roles": roles})
+}
+
+func (f *fakeDiscord) botBan(w http.ResponseWriter, _ *http.Request, userID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.bans[userID] {
+		writeDiscordError(w, http.StatusNotFound, 10026, "Unknown Ban")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"user": f.users[userID]})
+}
+
+func writeDiscordError(w http.ResponseWriter, status, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"code": code, "message": message, "error": message})
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	duplicatesReject = "reject"
+	duplicatesLink   = "link"
+)
+
+// duplicateError indica que los mismos bytes ya se subieron con otro nombre.
+type duplicateError struct {
+	Existing string
+}
+
+func (e *duplicateError) Error() string {
+	return "el archivo ya existe como " + e.Existing
+}
+
+type duplicateGroup struct {
+	Checksum string   `json:"checksum"`
+	Size     int64    `json:"size"`
+	Names    []string `json:"names"`
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// findDuplicate busca un sonido subido con el mismo contenido, o cuyo mp3
+// guardado es idéntico a lo subido (alguien que vuelve a subir un sonido
+// descargado de wasabi). Incluye los que todavía se están procesando.
+func (s *server) findDuplicate(ctx context.Context, checksum string) (string, bool, error) {
+	var rec soundRecord
+	err := s.soundsCollection.FindOne(ctx,
+		bson.M{"$or": bson.A{bson.M{"sourceChecksum": checksum}, bson.M{"checksum": checksum}}},
+		options.FindOne().SetProjection(bson.M{"name": 1}),
+	).Decode(&rec)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return rec.Name, true, nil
+}
+
+// linkDuplicate crea target con el contenido ya guardado de existing, sin
+// volver a convertirlo: se reserva el nombre, se copia el objeto y el
+// registro queda listo con el audio, las mediciones y la forma de onda del
+// original. Si algo falla se borra la copia y se libera el nombre.
+func (s *server) linkDuplicate(ctx context.Context, req ingestRequest, checksum string, existing soundRecord) error {
+	exists, err := s.objectExists(ctx, req.TargetName)
+	if err != nil {
+		return fmt.Errorf("no se pudo verificar destino: %w", err)
+	}
+	if exists {
+		return errSoundExists
+	}
+
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		title = titleFromName(req.TargetName)
+	}
+	now := time.Now().UTC()
+	err = s.insertSound(ctx, soundRecord{
+		Name:           req.TargetName,
+		Title:          title,
+		Status:         soundProcessing,
+		OriginalName:   req.OriginalName,
+		SourceChecksum: checksum,
+		OriginalFormat: strings.TrimPrefix(strings.ToLower(filepath.Ext(req.OriginalName)), "."),
+		DetectedFormat: existing.DetectedFormat,
+		UploaderID:     req.Claims.UserID,
+		UploaderName:   req.Claims.Username,
+		UploadedAt:     now,
+		ModifiedAt:     now,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := copyObject(ctx, s.store, s.store, existing.Name, req.TargetName); err != nil {
+		s.abandonReservation(ctx, req.TargetName)
+		return err
+	}
+	err = s.updateSoundAudio(ctx, req.TargetName, storedAudio{
+		Size:     existing.Size,
+		Checksum: existing.Checksum,
+		Audio:    existing.Audio,
+		Loudness: existing.Loudness,
+		Peaks:    existing.Peaks,
+	})
+	if err != nil {
+		if delErr := s.store.Delete(ctx, req.TargetName); delErr != nil {
+			log.Printf("error al revertir copia %s: %v", req.TargetName, delErr)
+		}
+		s.abandonReservation(ctx, req.TargetName)
+		return fmt.Errorf("no se pudo registrar %s: %w", req.TargetName, err)
+	}
+	return nil
+}
+
+// duplicateGroups agrupa los sonidos guardados por checksum. Los que no lo
+// tienen en el catálogo se leen del almacenamiento y se completa el registro.
+func (s *server) duplicateGroups(ctx context.Context) ([]duplicateGroup, error) {
+	records, err := s.findSounds(ctx, readySounds(bson.M{}))
+	if err != nil {
+		return nil, err
+	}
+
+	byChecksum := make(map[string]*duplicateGroup)
+	for _, rec := range records {
+		checksum := rec.Checksum
+		if checksum == "" {
+			checksum, err = s.objectChecksum(ctx, rec.Name)
+			if errors.Is(err, errObjectNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if _, err := s.soundsCollection.UpdateOne(ctx, bson.M{"name": rec.Name}, bson.M{"$set": bson.M{"checksum": checksum}}); err != nil {
+				log.Printf("no se pudo guardar checksum de %s: %v", rec.Name, err)
+			}
+		}
+
+		group, ok := byChecksum[checksum]
+		if !ok {
+			group = &duplicateGroup{Checksum: checksum, Size: rec.Size}
+			byChecksum[checksum] = group
+		}
+		group.Names = append(group.Names, rec.Name)
+	}
+
+	groups := []duplicateGroup{}
+	for _, group := range byChecksum {
+		if len(group.Names) < 2 {
+			continue
+		}
+		sort.Strings(group.Names)
+		groups = append(groups, *group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Names[0] < groups[j].Names[0] })
+	return groups, nil
+}
+
+func (s *server) duplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "solo se permite GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	groups, err := s.duplicateGroups(r.Context())
+	if err != nil {
+		log.Printf("error al buscar duplicados: %v", err)
+		http.Error(w, "no se pudieron buscar duplicados", http.StatusInternalServerError)
+		return
+	}
+
+	extra, wasted := 0, int64(0)
+	for _, group := range groups {
+		extra += len(group.Names) - 1
+		wasted += int64(len(group.Names)-1) * group.Size
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"groups":      groups,
+		"duplicates":  extra,
+		"wastedBytes": wasted,
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// Con DUPLICATE_POLICY=link el nombre pedido queda listo con una copia del
+// mp3 existente, sin encolar conversión.
+func TestIngestLinksDuplicate(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("copia el sonido existente", func(mt *mtest.T) {
+		store, err := newLocalStorage(t.TempDir())
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if err := store.Put(context.Background(), "original.mp3", strings.NewReader("mp3 convertido")); err != nil {
+			mt.Fatal(err)
+		}
+		s := &server{
+			store:            store,
+			soundsCollection: mt.Coll,
+			uploadPolicy:     uploadPolicy{Duplicates: duplicatesLink},
+		}
+
+		source := filepath.Join(t.TempDir(), "subida.wav")
+		if err := os.WriteFile(source, []byte("wav original"), 0o644); err != nil {
+			mt.Fatal(err)
+		}
+
+		existing := bson.D{
+			{Key: "name", Value: "original.mp3"},
+			{Key: "checksum", Value: "abc"},
+			{Key: "size", Value: int64(14)},
+			{Key: "detectedFormat", Value: "wav"},
+		}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.sounds", mtest.FirstBatch, bson.D{{Key: "name", Value: "original.mp3"}}),
+			mtest.CreateCursorResponse(0, "db.sounds", mtest.FirstBatch, existing),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+				{Key: "name", Value: "pedido.mp3"},
+				{Key: "checksum", Value: "abc"},
+			}}),
+		)
+
+		res, err := s.ingestUpload(context.Background(), ingestRequest{
+			SourcePath:     source,
+			SourceChecksum: "def",
+			OriginalName:   "subida.wav",
+			TargetName:     "pedido.mp3",
+			Claims:         &jwtClaims{UserID: "1001", Username: "alice"},
+		})
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if res.Name != "pedido.mp3" || res.DuplicateOf != "original.mp3" || res.JobID != "" {
+			mt.Fatalf("resultado = %+v", res)
+		}
+
+		content, _, err := store.Get(context.Background(), "pedido.mp3")
+		if err != nil {
+			mt.Fatal(err)
+		}
+		defer content.Close()
+		if data, _ := io.ReadAll(content); string(data) != "mp3 convertido" {
+			mt.Errorf("copia = %q", data)
+		}
+	})
+}
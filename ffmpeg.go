@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+var errOutputTooLarge = errors.New("el resultado de ffmpeg supera el tamaño máximo permitido")
+
+type ffmpegConfig struct {
+	Timeout       time.Duration
+	ProbeTimeout  time.Duration
+	CPULimit      time.Duration
+	MaxOutputSize int64
+}
+
+// ffmpegTimeoutError indica que ffmpeg o ffprobe se cortaron por exceder el
+// tiempo de reloj o de CPU configurado.
+// Con Caller el corte vino del plazo del trabajo o de la petición, y Limit es
+// el tiempo que le quedaba a la herramienta al empezar.
+type ffmpegTimeoutError struct {
+	Tool   string
+	Limit  time.Duration
+	CPU    bool
+	Caller bool
+}
+
+func (e *ffmpegTimeoutError) Error() string {
+	if e.CPU {
+		return fmt.Sprintf("%s superó el límite de %s de CPU", e.Tool, e.Limit)
+	}
+	if e.Caller {
+		return fmt.Sprintf("%s no terminó dentro del plazo del trabajo o de la petición (%s disponibles)", e.Tool, e.Limit)
+	}
+	return fmt.Sprintf("%s superó el tiempo límite de %s", e.Tool, e.Limit)
+}
+
+// ffmpegRunner centraliza la ejecución de ffmpeg y ffprobe para que todas las
+// conversiones respeten los mismos límites y usen la misma carpeta temporal.
+type ffmpegRunner struct {
+	cfg     ffmpegConfig
+	tempDir string
+}
+
+func newFFmpegRunner(cfg ffmpegConfig, tempDir string) (*ffmpegRunner, error) {
+	if err := os.MkdirAll(tempDir, 0o755); err != nil {
+		return nil, fmt.Errorf("no se pudo crear carpeta temporal: %w", err)
+	}
+
+	// Lo viejo que quede aquí es de una ejecución que terminó sin limpiar.
+	removeStaleFiles(tempDir, "*")
+
+	return &ffmpegRunner{cfg: cfg, tempDir: tempDir}, nil
+}
+
+// tempFile reserva una ruta en la carpeta temporal; quien la pide la borra.
+func (f *ffmpegRunner) tempFile(pattern string) (string, error) {
+	tmp, err := os.CreateTemp(f.tempDir, pattern)
+	if err != nil {
+		return "", fmt.Errorf("no se pudo preparar el archivo temporal: %w", err)
+	}
+	name := tmp.Name()
+	tmp.Close()
+	return name, nil
+}
+
+// ffmpeg ejecuta ffmpeg con los límites configurados y devuelve su stderr,
+// donde ffmpeg escribe tanto los errores como las mediciones de loudnorm.
+func (f *ffmpegRunner) ffmpeg(ctx context.Context, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	err := f.run(ctx, "ffmpeg", f.cfg.Timeout, f.ffmpegArgs(args), nil, &stderr)
+	return stderr.Bytes(), err
+}
+
+// ffmpegStream es como ffmpeg pero entrega a stdout lo que ffmpeg escribe
+// mientras lo escribe, sin juntarlo en memoria: -fs no limita la salida
+// estándar.
+func (f *ffmpegRunner) ffmpegStream(ctx context.Context, stdout io.Writer, args ...string) error {
+	var stderr bytes.Buffer
+	return f.run(ctx, "ffmpeg", f.cfg.Timeout, f.ffmpegArgs(args), stdout, &stderr)
+}
+
+func (f *ffmpegRunner) ffprobe(ctx context.Context, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	if err := f.run(ctx, "ffprobe", f.cfg.ProbeTimeout, args, &stdout, &stderr); err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+func (f *ffmpegRunner) ffmpegArgs(args []string) []string {
+	base := []string{"-hide_banner", "-nostats", "-nostdin"}
+	if f.cfg.CPULimit > 0 {
+		base = append(base, "-timelimit", strconv.Itoa(int(f.cfg.CPULimit.Seconds())))
+	}
+	return append(base, args...)
+}
+
+// run mata el proceso si vence el tiempo límite o si se cancela el contexto
+// de la petición o del trabajo.
+func (f *ffmpegRunner) run(ctx context.Context, tool string, timeout time.Duration, args []string, stdout io.Writer, stderr *bytes.Buffer) error {
+	start := time.Now()
+	runCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(runCtx, tool, args...)
+	if stdout != nil {
+		cmd.Stdout = stdout
+	}
+	cmd.Stderr = stderr
+	cmd.WaitDelay = 5 * time.Second
+
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+
+	// Vencer el plazo del trabajo o de la petición también cuenta como timeout,
+	// pero el error informa ese plazo y no el propio.
+	if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		if deadline, ok := ctx.Deadline(); ok && ctx.Err() != nil {
+			return &ffmpegTimeoutError{Tool: tool, Limit: deadline.Sub(start).Round(time.Millisecond), Caller: true}
+		}
+		return &ffmpegTimeoutError{Tool: tool, Limit: timeout}
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%s cancelado: %w", tool, ctxErr)
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() && status.Signal() == syscall.SIGXCPU {
+			return &ffmpegTimeoutError{Tool: tool, Limit: f.cfg.CPULimit, CPU: true}
+		}
+	}
+
+	return fmt.Errorf("%v: %s", err, lastLines(stderr.Bytes(), 5))
+}
+
+// outputArgs agrega el límite de tamaño antes de la ruta de salida. Con -fs
+// ffmpeg corta la escritura sin fallar, por eso checkOutput revisa después.
+func (f *ffmpegRunner) outputArgs(outputPath string) []string {
+	if f.cfg.MaxOutputSize <= 0 {
+		return []string{outputPath}
+	}
+	return []string{"-fs", strconv.FormatInt(f.cfg.MaxOutputSize, 10), outputPath}
+}
+
+func (f *ffmpegRunner) checkOutput(outputPath string) error {
+	if f.cfg.MaxOutputSize <= 0 {
+		return nil
+	}
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		return fmt.Errorf("no se pudo leer el resultado de ffmpeg: %w", err)
+	}
+	if info.Size() >= f.cfg.MaxOutputSize {
+		return errOutputTooLarge
+	}
+	return nil
+}
+
+func lastLines(output []byte, n int) []byte {
+	output = bytes.TrimSpace(output)
+	lines := bytes.Split(output, []byte("\n"))
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
+
+func isFFmpegTimeout(err error) bool {
+	var timeout *ffmpegTimeoutError
+	return errors.As(err, &timeout)
+}
+
+// writeProcessingError responde según la causa de una falla al procesar
+// audio; message se usa para los errores internos.
+func writeProcessingError(w http.ResponseWriter, err error, message string) {
+	var timeout *ffmpegTimeoutError
+	switch {
+	case errors.As(err, &timeout):
+		http.Error(w, "el procesamiento del audio tardó demasiado: "+timeout.Error(), http.StatusGatewayTimeout)
+	case errors.Is(err, errBusy):
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "hay demasiadas conversiones en curso, intenta de nuevo en unos segundos", http.StatusServiceUnavailable)
+	case errors.Is(err, errOutputTooLarge):
+		http.Error(w, "el audio resultante supera el tamaño máximo permitido", http.StatusUnprocessableEntity)
+	case errors.Is(err, context.Canceled):
+		// El cliente se desconectó; no hay a quién responder.
+	default:
+		http.Error(w, message, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// sleep reemplaza a ffmpeg: solo importa qué plazo lo corta.
+func TestRunTimeoutReportsLimit(t *testing.T) {
+	f := &ffmpegRunner{}
+
+	var stderr bytes.Buffer
+	err := f.run(context.Background(), "sleep", 50*time.Millisecond, []string{"5"}, nil, &stderr)
+	var timeout *ffmpegTimeoutError
+	if !errors.As(err, &timeout) || timeout.Caller || timeout.Limit != 50*time.Millisecond {
+		t.Fatalf("límite propio: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = f.run(ctx, "sleep", time.Minute, []string{"5"}, nil, &stderr)
+	if !errors.As(err, &timeout) || !timeout.Caller || timeout.Limit > 50*time.Millisecond {
+		t.Fatalf("plazo del contexto: %v", err)
+	}
+}
+
+// Otra instancia con el mismo DATA_DIR puede estar escribiendo los recientes.
+func TestRemoveStaleFilesKeepsRecent(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, ".incoming-viejo")
+	recent := filepath.Join(dir, ".incoming-nuevo")
+	for _, path := range []string{old, recent} {
+		if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	past := time.Now().Add(-2 * staleFileAge)
+	os.Chtimes(old, past, past)
+
+	removeStaleFiles(dir, ".incoming-*")
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("el temporal abandonado sigue: %v", err)
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("se borró un temporal reciente: %v", err)
+	}
+}
@@ -9,7 +9,7 @@ import {
   Trash,
   WaveSine,
 } from "phosphor-react";
-import { fileUrl } from "../services/api.js";
+import { fetchWaveform, fileUrl } from "../services/api.js";
 import { displayName, ensureExtension } from "../utils/fileNames.js";
 
 const PAGE_SIZE = 9;
@@ -44,6 +44,22 @@ function formatTime(seconds) {
   return `${mins}:${secs}`;
 }
 
+function Waveform({ peaks }) {
+  if (!peaks.length) return null;
+  const width = peaks.length;
+  const path = peaks
+    .map((peak, index) => {
+      const height = Math.max(peak, 0.02) * 10;
+      return `M${index + 0.5} ${10 - height}V${10 + height}`;
+    })
+    .join("");
+  return (
+    <svg className="waveform" viewBox={`0 0 ${width} 20`} preserveAspectRatio="none" aria-hidden="true">
+      <path d={path} />
+    </svg>
+  );
+}
+
 function FileCard({ file, onRename, onDelete, disabled }) {
   const [editing, setEditing] = useState(false);
   const baseName = useMemo(() => displayName(file.name), [file.name]);
@@ -52,11 +68,24 @@ function FileCard({ file, onRename, onDelete, disabled }) {
   const [playing, setPlaying] = useState(false);
   const audioSource = useMemo(() => fileUrl(file.name), [file.name]);
   const audioRef = useRef(null);
+  const [peaks, setPeaks] = useState([]);
 
   useEffect(() => {
     setNewName(baseName);
   }, [baseName]);
 
+  useEffect(() => {
+    let cancelled = false;
+    fetchWaveform(file.name)
+      .then((values) => {
+        if (!cancelled) setPeaks(values);
+      })
+      .catch(() => {});
+    return () => {
+      cancelled = true;
+    };
+  }, [file.name, file.checksum]);
+
   const handleRename = async (event) => {
     event.preventDefault();
     setLocalError("");
@@ -178,10 +207,14 @@ function FileCard({ file, onRename, onDelete, disabled }) {
         >
           {playing ? <PauseCircle size={26} weight="fill" /> : <PlayCircle size={26} weight="fill" />}
         </button>
-        <div className="player-meta horizontal micro">
-          <WaveSine size={14} weight="bold" />
-          <span className="muted tiny">Reproducir</span>
-        </div>
+        {peaks.length ? (
+          <Waveform peaks={peaks} />
+        ) : (
+          <div className="player-meta horizontal micro">
+            <WaveSine size={14} weight="bold" />
+            <span className="muted tiny">Reproducir</span>
+          </div>
+        )}
         <audio
           ref={audioRef}
           className="sr-audio"
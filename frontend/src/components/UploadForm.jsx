@@ -1,4 +1,4 @@
-import { useState } from "react";
+import { useEffect, useState } from "react";
 import {
   CheckCircle,
   CloudArrowUp,
@@ -8,6 +8,7 @@ import {
   WaveSine,
 } from "phosphor-react";
 import Mirt from "react-mirt";
+import { fetchFormats } from "../services/api.js";
 import { trimAudioFile } from "../services/audio.js";
 import "react-mirt/dist/css/react-mirt.css";
 
@@ -37,6 +38,23 @@ function UploadForm({ onSubmit, busy }) {
   const [duration, setDuration] = useState(0);
   const [waveformLoading, setWaveformLoading] = useState(false);
   const [localError, setLocalError] = useState("");
+  const [formats, setFormats] = useState({ accept: "audio/*", names: "MP3, WAV o M4A" });
+
+  useEffect(() => {
+    let cancelled = false;
+    fetchFormats()
+      .then((payload) => {
+        if (cancelled || !payload?.formats?.length) return;
+        setFormats({
+          accept: payload.accept || "audio/*",
+          names: payload.formats.map((format) => format.name.toUpperCase()).join(", "),
+        });
+      })
+      .catch(() => {});
+    return () => {
+      cancelled = true;
+    };
+  }, []);
 
   const resetForm = (target) => {
     setFile(null);
@@ -117,7 +135,7 @@ function UploadForm({ onSubmit, busy }) {
             <p className="file-drop__title">
               {file ? file.name : "Arrastra o selecciona un audio"}
             </p>
-            <p className="muted tiny">MP3, WAV o M4A · se recorta localmente y se envía en WAV</p>
+            <p className="muted tiny">{formats.names} · se recorta localmente y se envía en WAV</p>
           </div>
           <div className="file-drop__cta">Elegir archivo</div>
         </label>
@@ -125,7 +143,7 @@ function UploadForm({ onSubmit, busy }) {
           id="file-upload"
           className="file-input"
           type="file"
-          accept="audio/*"
+          accept={formats.accept}
           onChange={handleFileChange}
           disabled={disabled}
           required
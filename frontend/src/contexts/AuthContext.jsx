@@ -1,5 +1,5 @@
 import { createContext, useContext, useEffect, useState } from "react";
-import { API_BASE } from "../services/api";
+import { API_BASE, fetchWithCredentials, refreshSession } from "../services/api";
 
 const AuthContext = createContext(null);
 
@@ -12,11 +12,26 @@ export function AuthProvider({ children }) {
     checkAuth();
   }, []);
 
+  // Renueva la sesión un minuto antes de que venza el access token, para que
+  // la reproducción de audios (que no pasa por fetch) siga funcionando.
+  useEffect(() => {
+    if (!user?.accessExpiresAt) {
+      return undefined;
+    }
+    const delay = Math.max(new Date(user.accessExpiresAt).getTime() - Date.now() - 60_000, 5_000);
+    const timer = setTimeout(async () => {
+      if (await refreshSession()) {
+        checkAuth();
+      } else {
+        setUser(null);
+      }
+    }, delay);
+    return () => clearTimeout(timer);
+  }, [user]);
+
   const checkAuth = async () => {
     try {
-      const response = await fetch(`${API_BASE}/auth/me`, {
-        credentials: "include",
-      });
+      const response = await fetchWithCredentials(`${API_BASE}/auth/me`);
 
       if (response.ok) {
         const userData = await response.json();
@@ -17,11 +17,38 @@ async function handleResponse(response) {
   return payload;
 }
 
-const fetchWithCredentials = (url, options = {}) => {
-  return fetch(url, {
-    ...options,
-    credentials: "include",
-  });
+let refreshing = null;
+
+// Renueva el access token con la cookie de refresh. Las peticiones que fallan
+// a la vez comparten una sola renovación, porque reutilizar el refresh token
+// cierra la sesión.
+export function refreshSession() {
+  if (!refreshing) {
+    refreshing = fetch(`${API_BASE}/auth/refresh`, {
+      method: "POST",
+      credentials: "include",
+    })
+      .then((response) => response.ok)
+      .catch(() => false)
+      .finally(() => {
+        refreshing = null;
+      });
+  }
+  return refreshing;
+}
+
+export const fetchWithCredentials = async (url, options = {}) => {
+  const request = () =>
+    fetch(url, {
+      ...options,
+      credentials: "include",
+    });
+
+  const response = await request();
+  if (response.status !== 401 || !(await refreshSession())) {
+    return response;
+  }
+  return request();
 };
 
 export async function fetchFiles() {
@@ -34,12 +61,49 @@ export function fileUrl(name) {
   return `${API_BASE}/files/${encodeURIComponent(name)}`;
 }
 
+export async function fetchFormats() {
+  const response = await fetchWithCredentials(`${API_BASE}/formats`);
+  return handleResponse(response);
+}
+
+export async function fetchJob(id) {
+  const response = await fetchWithCredentials(`${API_BASE}/jobs/${encodeURIComponent(id)}`);
+  return handleResponse(response);
+}
+
+const JOB_POLL_INTERVAL_MS = 1000;
+
+async function waitForJob(id) {
+  for (;;) {
+    const job = await fetchJob(id);
+    if (job.status === "done") {
+      return job;
+    }
+    if (job.status === "failed") {
+      throw new Error(job.error || "La conversión falló");
+    }
+    await new Promise((resolve) => setTimeout(resolve, JOB_POLL_INTERVAL_MS));
+  }
+}
+
+export async function fetchWaveform(name, points = 120) {
+  const response = await fetchWithCredentials(
+    `${API_BASE}/files/${encodeURIComponent(name)}/waveform?points=${points}`,
+  );
+  const payload = await handleResponse(response);
+  return Array.isArray(payload?.peaks) ? payload.peaks : [];
+}
+
 export async function uploadFile(formData) {
   const response = await fetchWithCredentials(`${API_BASE}/upload`, {
     method: "POST",
     body: formData,
   });
-  return handleResponse(response);
+  const payload = await handleResponse(response);
+  if (payload && payload.jobId) {
+    await waitForJob(payload.jobId);
+  }
+  return payload;
 }
 
 export async function renameFile(currentName, newName) {
@@ -4,6 +4,8 @@ go 1.24.2
 
 require github.com/golang-jwt/jwt/v5 v5.3.0
 
+require github.com/davecgh/go-spew v1.1.1 // indirect
+
 require (
 	github.com/golang/snappy v0.0.4 // indirect
 	github.com/klauspost/compress v1.13.6 // indirect
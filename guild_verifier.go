@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type membershipStatus string
+
+const (
+	memberActive membershipStatus = "member"
+	memberLeft   membershipStatus = "left"
+	memberBanned membershipStatus = "banned"
+)
+
+// Una espera de Discord más larga que esto corta la ronda; se sigue en la
+// próxima.
+const maxRateLimitWait = 30 * time.Second
+
+type rateLimitError struct {
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("límite de peticiones de Discord, reintentar en %s", e.retryAfter)
+}
+
+type membershipEntry struct {
+	status    membershipStatus
+	checkedAt time.Time
+}
+
+// membershipCache guarda el último resultado por usuario para no consultar a
+// Discord en cada ronda, y para rechazar enseguida a quien ya se sabe que no
+// es miembro.
+type membershipCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]membershipEntry
+}
+
+func newMembershipCache(ttl time.Duration) *membershipCache {
+	return &membershipCache{ttl: ttl, entries: make(map[string]membershipEntry)}
+}
+
+func (c *membershipCache) get(userID string) (membershipStatus, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[userID]
+	if !ok || time.Since(entry.checkedAt) > c.ttl {
+		return "", false
+	}
+	return entry.status, true
+}
+
+func (c *membershipCache) set(userID string, status membershipStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[userID] = membershipEntry{status: status, checkedAt: time.Now()}
+}
+
+// rejects es verdadero si una verificación reciente dijo que el usuario ya no
+// está en el servidor.
+func (c *membershipCache) rejects(userID string) bool {
+	status, ok := c.get(userID)
+	return ok && status != memberActive
+}
+
+func (c *membershipCache) prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, entry := range c.entries {
+		if time.Since(entry.checkedAt) > c.ttl {
+			delete(c.entries, id)
+		}
+	}
+}
+
+// Códigos de error de Discord que confirman que el usuario no está. Un 404
+// con otro código (por ejemplo Unknown Guild si sacaron al bot) no dice nada
+// del usuario y no debe cerrar sesiones.
+const (
+	discordUnknownMember = 10007
+	discordUnknownUser   = 10013
+)
+
+// fetchMemberStatus consulta con el token del bot si el usuario sigue en el
+// servidor y, si no está, si fue baneado. Si sigue, devuelve también sus roles
+// de Discord. Ver los baneos requiere el permiso BAN_MEMBERS; sin él, un
+// baneado se informa como que se fue.
+func (a *authService) fetchMemberStatus(ctx context.Context, userID string) (membershipStatus, []string, error) {
+	guildPath := a.discordAPI("/guilds/" + url.PathEscape(a.requiredGuildID))
+
+	var member struct {
+		Roles []string `json:"roles"`
+	}
+	status, code, err := a.botRequest(ctx, guildPath+"/members/"+url.PathEscape(userID), &member)
+	if err != nil {
+		return "", nil, err
+	}
+	if status == http.StatusOK {
+		return memberActive, member.Roles, nil
+	}
+	if status != http.StatusNotFound || (code != discordUnknownMember && code != discordUnknownUser) {
+		return "", nil, fmt.Errorf("código de estado: %d (error de Discord %d)", status, code)
+	}
+
+	status, _, err = a.botRequest(ctx, guildPath+"/bans/"+url.PathEscape(userID), nil)
+	if err != nil {
+		return "", nil, err
+	}
+	if status == http.StatusOK {
+		return memberBanned, nil, nil
+	}
+	return memberLeft, nil, nil
+}
+
+// botRequest hace un GET con el token del bot y devuelve el estado HTTP y,
+// si hubo error, el código de error de Discord. Con 200 decodifica la
+// respuesta en out si no es nil.
+func (a *authService) botRequest(ctx context.Context, endpoint string, out any) (int, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error al crear petición: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bot "+a.botToken)
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error en petición: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		if out != nil {
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				return 0, 0, fmt.Errorf("error al leer respuesta: %w", err)
+			}
+		}
+		return resp.StatusCode, 0, nil
+	}
+
+	var body struct {
+		Code       int     `json:"code"`
+		RetryAfter float64 `json:"retry_after"`
+	}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&body)
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		wait := time.Second
+		if decodeErr == nil && body.RetryAfter > 0 {
+			wait = time.Duration(body.RetryAfter * float64(time.Second))
+		} else if secs, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); err == nil {
+			wait = time.Duration(secs * float64(time.Second))
+		}
+		return 0, 0, &rateLimitError{retryAfter: wait}
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return 0, 0, fmt.Errorf("Discord rechazó el token del bot")
+	}
+
+	return resp.StatusCode, body.Code, nil
+}
+
+// memberStatus usa el caché si está vigente y si no consulta a Discord,
+// esperando una vez si Discord pide bajar el ritmo. Con una consulta nueva
+// también baja los tokens de API al rol que el miembro tiene hoy.
+func (s *server) memberStatus(ctx context.Context, userID string) (membershipStatus, error) {
+	if status, ok := s.membership.get(userID); ok {
+		return status, nil
+	}
+
+	status, roles, err := s.auth.fetchMemberStatus(ctx, userID)
+	var limited *rateLimitError
+	if errors.As(err, &limited) && limited.retryAfter <= maxRateLimitWait {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(limited.retryAfter):
+		}
+		status, roles, err = s.auth.fetchMemberStatus(ctx, userID)
+	}
+	if err != nil {
+		return "", err
+	}
+	if status == memberActive {
+		if err := s.capUserRole(ctx, userID, s.auth.resolveRole(userID, roles), ""); err != nil {
+			return "", err
+		}
+	}
+
+	s.membership.set(userID, status)
+	return status, nil
+}
+
+// activeUserIDs junta a los usuarios con alguna sesión viva o token de API.
+func (s *server) activeUserIDs(ctx context.Context) ([]string, error) {
+	sessionUsers, err := s.sessionsCollection.Distinct(ctx, "userId", bson.M{
+		"revokedAt": bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": time.Now().UTC()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	tokenUsers, err := s.tokensCollection.Distinct(ctx, "userId", bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var ids []string
+	for _, raw := range append(sessionUsers, tokenUsers...) {
+		id, ok := raw.(string)
+		if !ok || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// verifyMembers revisa a los usuarios activos y corta el acceso de quienes
+// dejaron el servidor o fueron baneados: revoca sus sesiones y borra sus
+// tokens de API. El error de un usuario se registra y se sigue con el resto;
+// la ronda solo se corta si Discord pide esperar demasiado o vence ctx.
+func (s *server) verifyMembers(ctx context.Context) (checked, removed int, err error) {
+	ids, err := s.activeUserIDs(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("no se pudieron listar usuarios activos: %w", err)
+	}
+
+	failed := 0
+	for _, id := range ids {
+		status, err := s.memberStatus(ctx, id)
+		var limited *rateLimitError
+		if errors.As(err, &limited) || ctx.Err() != nil {
+			return checked, removed, fmt.Errorf("no se pudo verificar a %s: %w", id, err)
+		}
+		if err != nil {
+			log.Printf("membresía: no se pudo verificar a %s: %v", id, err)
+			failed++
+			continue
+		}
+		checked++
+		if status == memberActive {
+			continue
+		}
+
+		if err := s.removeMember(ctx, id, status); err != nil {
+			if ctx.Err() != nil {
+				return checked, removed, err
+			}
+			log.Printf("membresía: %v", err)
+			failed++
+			continue
+		}
+		removed++
+	}
+	if failed > 0 {
+		return checked, removed, fmt.Errorf("no se pudo verificar o revocar a %d de %d usuarios", failed, len(ids))
+	}
+	return checked, removed, nil
+}
+
+// removeMember revoca las sesiones y borra los tokens de API de quien ya no
+// está en el servidor.
+func (s *server) removeMember(ctx context.Context, id string, status membershipStatus) error {
+	sessions, err := s.revokeUserSessions(ctx, id, "", "guild_"+string(status))
+	if err != nil {
+		return fmt.Errorf("no se pudieron revocar sesiones de %s: %w", id, err)
+	}
+	tokens, err := s.revokeUserAPITokens(ctx, id)
+	if err != nil {
+		return fmt.Errorf("no se pudieron revocar tokens de %s: %w", id, err)
+	}
+	log.Printf("membresía: user_id=%s estado=%s sesiones=%d tokens=%d", id, status, sessions, tokens)
+	return nil
+}
+
+func (s *server) runGuildVerifier(ctx context.Context) {
+	ticker := time.NewTicker(s.verifyInterval)
+	defer ticker.Stop()
+
+	for {
+		verifyCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+		checked, removed, err := s.verifyMembers(verifyCtx)
+		cancel()
+		if err != nil {
+			log.Printf("error al verificar membresías (%d revisados): %v", checked, err)
+		} else if removed > 0 {
+			log.Printf("membresía: %d de %d usuarios ya no están en el servidor", removed, checked)
+		}
+		s.membership.prune()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
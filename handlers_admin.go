@@ -0,0 +1,100 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// exportHandler descarga un respaldo con todos los sonidos, el catálogo y las
+// intros. Por defecto es tar.gz; con ?format=zip se genera un zip.
+func (s *server) exportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "solo se permite GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format")))
+	if format == "" {
+		format = "tar.gz"
+	}
+	if format != "tar.gz" && format != "zip" {
+		http.Error(w, "formato de respaldo no soportado, usa tar.gz o zip", http.StatusBadRequest)
+		return
+	}
+
+	// Se lee todo lo de Mongo antes de empezar a responder, para poder
+	// devolver un error normal si falla.
+	sounds, intros, err := s.libraryMetadata(r.Context())
+	if err != nil {
+		log.Printf("error al preparar exportación: %v", err)
+		http.Error(w, "no se pudo exportar la biblioteca", http.StatusInternalServerError)
+		return
+	}
+
+	fileName := fmt.Sprintf("wasabi-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	var aw archiveWriter
+	if format == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+		aw = &zipArchiveWriter{zw: zip.NewWriter(w)}
+	} else {
+		w.Header().Set("Content-Type", "application/gzip")
+		aw = newTarGzWriter(w)
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+
+	claims, _ := getUserClaims(r.Context())
+	if err := s.exportLibrary(r.Context(), aw, sounds, intros); err != nil {
+		log.Printf("exportación interrumpida: %v", err)
+		return
+	}
+	log.Printf("biblioteca exportada: user_id=%s sonidos=%d intros=%d formato=%s", claims.UserID, len(sounds), len(intros), format)
+}
+
+// importHandler restaura un respaldo de /admin/export. ?strategy=merge (por
+// defecto) conserva lo existente; overwrite lo reemplaza.
+func (s *server) importHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "solo se permite POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	strategy := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("strategy")))
+	if strategy == "" {
+		strategy = importMerge
+	}
+	if strategy != importMerge && strategy != importOverwrite {
+		http.Error(w, "estrategia inválida, usa merge u overwrite", http.StatusBadRequest)
+		return
+	}
+
+	// El zip necesita acceso aleatorio, así que el respaldo se guarda primero.
+	archivePath, _, err := s.spoolUpload(r.Body)
+	if err != nil {
+		log.Printf("error al recibir respaldo: %v", err)
+		http.Error(w, "no se pudo recibir el respaldo", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(archivePath)
+
+	report, err := s.importLibrary(r.Context(), archivePath, strategy)
+	if errors.Is(err, errInvalidArchive) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("importación interrumpida: %v", err)
+		http.Error(w, "no se pudo importar el respaldo", http.StatusInternalServerError)
+		return
+	}
+
+	claims, _ := getUserClaims(r.Context())
+	log.Printf("biblioteca importada: user_id=%s estrategia=%s sonidos=%d omitidos=%d intros=%d",
+		claims.UserID, strategy, report.SoundsRestored, report.SoundsSkipped, report.IntrosRestored)
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func (s *server) fileActionHandler(w http.ResponseWriter, r *http.Request, name, action string) {
+	switch action {
+	case "normalize":
+		if r.Method != http.MethodPost {
+			http.Error(w, "solo se permite POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if s.authorizeSoundChange(w, r, name) {
+			s.normalizeFile(w, r, name)
+		}
+	case "waveform":
+		s.waveformHandler(w, r, name)
+	case "edit":
+		if r.Method != http.MethodPost {
+			http.Error(w, "solo se permite POST", http.StatusMethodNotAllowed)
+			return
+		}
+		s.editFile(w, r, name)
+	default:
+		http.Error(w, "ruta inválida", http.StatusNotFound)
+	}
+}
+
+func (s *server) normalizeFile(w http.ResponseWriter, r *http.Request, name string) {
+	release, err := s.tryAcquireTranscode()
+	if err != nil {
+		writeProcessingError(w, err, "no se pudo normalizar el archivo")
+		return
+	}
+	defer release()
+
+	inputPath, err := s.downloadToTemp(r.Context(), name)
+	if errors.Is(err, errObjectNotFound) {
+		http.Error(w, "archivo no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error al leer %s para normalizar: %v", name, err)
+		http.Error(w, "no se pudo leer el archivo", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(inputPath)
+
+	stored, err := s.normalizeAndSave(r.Context(), inputPath, name)
+	if err != nil {
+		log.Printf("error al normalizar %s: %v", name, err)
+		writeProcessingError(w, err, "no se pudo normalizar el archivo")
+		return
+	}
+
+	if err := s.updateSoundAudio(r.Context(), name, stored); err != nil {
+		log.Printf("error al actualizar catálogo de %s: %v", name, err)
+		http.Error(w, "no se pudo registrar el archivo", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"message":  "archivo normalizado",
+		"name":     name,
+		"loudness": stored.Loudness,
+	})
+}
+
+type editRequest struct {
+	Start            float64  `json:"start"`
+	End              float64  `json:"end"`
+	FadeIn           float64  `json:"fadeIn"`
+	FadeOut          float64  `json:"fadeOut"`
+	TrimSilence      bool     `json:"trimSilence"`
+	SilenceThreshold *float64 `json:"silenceThreshold"`
+	SaveAs           string   `json:"saveAs"`
+	Title            string   `json:"title"`
+}
+
+func (req editRequest) options() (editOptions, error) {
+	opts := editOptions{
+		Start:            req.Start,
+		End:              req.End,
+		FadeIn:           req.FadeIn,
+		FadeOut:          req.FadeOut,
+		TrimSilence:      req.TrimSilence,
+		SilenceThreshold: -50,
+	}
+	if req.SilenceThreshold != nil {
+		opts.SilenceThreshold = *req.SilenceThreshold
+	}
+
+	if opts.Start < 0 || opts.End < 0 || opts.FadeIn < 0 || opts.FadeOut < 0 {
+		return editOptions{}, fmt.Errorf("los tiempos no pueden ser negativos")
+	}
+	if opts.End > 0 && opts.End <= opts.Start {
+		return editOptions{}, fmt.Errorf("end debe ser mayor que start")
+	}
+	if opts.SilenceThreshold > 0 {
+		return editOptions{}, fmt.Errorf("silenceThreshold debe estar en dB negativos")
+	}
+	if len(opts.filters()) == 0 {
+		return editOptions{}, fmt.Errorf("no se indicó ninguna edición")
+	}
+	return opts, nil
+}
+
+func (s *server) editFile(w http.ResponseWriter, r *http.Request, name string) {
+	claims, ok := getUserClaims(r.Context())
+	if !ok {
+		http.Error(w, "no se pudo obtener usuario", http.StatusInternalServerError)
+		return
+	}
+
+	var payload editRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "cuerpo JSON inválido", http.StatusBadRequest)
+		return
+	}
+
+	opts, err := payload.options()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	release, err := s.tryAcquireTranscode()
+	if err != nil {
+		writeProcessingError(w, err, "no se pudo editar el archivo")
+		return
+	}
+	defer release()
+
+	targetName := name
+	replace := strings.TrimSpace(payload.SaveAs) == ""
+	if replace {
+		if !s.authorizeSoundChange(w, r, name) {
+			return
+		}
+	} else {
+		safeName, err := sanitizeName(payload.SaveAs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		targetName = ensureMP3Name(safeName)
+
+		exists, err := s.objectExists(r.Context(), targetName)
+		if err != nil {
+			log.Printf("error al verificar destino: %v", err)
+			http.Error(w, "no se pudo editar el archivo", http.StatusInternalServerError)
+			return
+		}
+		if exists {
+			http.Error(w, "ya existe un archivo con ese nombre", http.StatusConflict)
+			return
+		}
+
+		// Igual que una subida, el nombre se reserva en el catálogo antes de
+		// escribir el archivo; si la edición falla la reserva se libera.
+		title := strings.TrimSpace(payload.Title)
+		if title == "" {
+			title = titleFromName(targetName)
+		}
+		now := time.Now().UTC()
+		err = s.insertSound(r.Context(), soundRecord{
+			Name:           targetName,
+			Title:          title,
+			Status:         soundProcessing,
+			OriginalName:   name,
+			OriginalFormat: "mp3",
+			UploaderID:     claims.UserID,
+			UploaderName:   claims.Username,
+			UploadedAt:     now,
+			ModifiedAt:     now,
+		})
+		if errors.Is(err, errSoundExists) {
+			http.Error(w, "ya existe un archivo con ese nombre", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("error al reservar %s en catálogo: %v", targetName, err)
+			http.Error(w, "no se pudo registrar el archivo", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	stored, err := s.editToStore(r.Context(), name, targetName, opts, replace)
+	if err != nil && !replace {
+		s.abandonReservation(context.WithoutCancel(r.Context()), targetName)
+	}
+	var rejected *policyError
+	if errors.As(err, &rejected) {
+		log.Printf("edición de %s rechazada por política: %v", name, err)
+		writePolicyRejection(w, rejected)
+		return
+	}
+	if errors.Is(err, errObjectNotFound) {
+		http.Error(w, "archivo no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error al editar %s: %v", name, err)
+		writeProcessingError(w, err, "no se pudo editar el archivo")
+		return
+	}
+
+	if err := s.updateSoundAudio(r.Context(), targetName, stored); err != nil {
+		log.Printf("error al actualizar catálogo de %s: %v", targetName, err)
+		if !replace {
+			// El nombre sigue reservado por esta petición: el archivo es
+			// nuestro y se puede borrar sin tocar otro sonido.
+			ctx := context.WithoutCancel(r.Context())
+			if delErr := s.store.Delete(ctx, targetName); delErr != nil {
+				log.Printf("error al revertir archivo %s: %v", targetName, delErr)
+			}
+			s.abandonReservation(ctx, targetName)
+		}
+		http.Error(w, "no se pudo registrar el archivo", http.StatusInternalServerError)
+		return
+	}
+
+	if replace {
+		log.Printf("archivo editado: user_id=%s sound=%s", claims.UserID, targetName)
+		writeJSON(w, http.StatusOK, map[string]string{"message": "archivo editado", "name": targetName})
+		return
+	}
+
+	log.Printf("archivo editado como nuevo sonido: user_id=%s sound=%s new_name=%s", claims.UserID, name, targetName)
+	writeJSON(w, http.StatusCreated, map[string]string{"message": "archivo editado", "name": targetName})
+}
+
+// editToStore aplica la edición a name y guarda el resultado como targetName.
+// Un sonido nuevo pasa por la misma política y normalización que una subida.
+func (s *server) editToStore(ctx context.Context, name, targetName string, opts editOptions, replace bool) (storedAudio, error) {
+	inputPath, err := s.downloadToTemp(ctx, name)
+	if err != nil {
+		return storedAudio{}, err
+	}
+	defer os.Remove(inputPath)
+
+	editedPath, err := s.ffmpeg.tempFile(".tmp-edit-*.mp3")
+	if err != nil {
+		return storedAudio{}, err
+	}
+	defer os.Remove(editedPath)
+
+	if err := s.ffmpeg.editToMP3(ctx, inputPath, editedPath, opts); err != nil {
+		return storedAudio{}, err
+	}
+	if replace {
+		return s.replaceWithEdit(ctx, editedPath, targetName)
+	}
+
+	if err := s.checkEditedAudio(ctx, editedPath); err != nil {
+		return storedAudio{}, err
+	}
+	return s.convertAndSaveAsMP3(ctx, editedPath, ".mp3", targetName)
+}
+
+// checkEditedAudio aplica al resultado de una edición las reglas de
+// uploadPolicy, incluida la sonoridad que en una subida revisa el worker.
+func (s *server) checkEditedAudio(ctx context.Context, path string) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	info, err := s.ffmpeg.probeAudio(ctx, path)
+	if err != nil {
+		return fmt.Errorf("no se pudo analizar el audio editado: %w", err)
+	}
+	if err := s.uploadPolicy.check(stat.Size(), info); err != nil {
+		return err
+	}
+	return s.uploadPolicy.checkLoudness(ctx, s.ffmpeg, path)
+}
+
+// replaceWithEdit guarda la edición sobre el mismo sonido. Con loudnorm
+// activo se vuelve a normalizar; si no, un sonido ya normalizado se vuelve a
+// medir para que su registro describa el audio nuevo.
+func (s *server) replaceWithEdit(ctx context.Context, path, name string) (storedAudio, error) {
+	if s.loudnorm.Enabled {
+		return s.normalizeAndSave(ctx, path, name)
+	}
+
+	rec, err := s.findSound(ctx, name)
+	if err != nil && !errors.Is(err, errSoundNotFound) {
+		return storedAudio{}, err
+	}
+	var loudness *loudnessRecord
+	if rec.Loudness != nil {
+		measured, err := s.ffmpeg.measureLoudness(ctx, path, rec.Loudness.Target)
+		if err != nil {
+			return storedAudio{}, err
+		}
+		updated := *rec.Loudness
+		updated.Output = measured
+		loudness = &updated
+	}
+
+	stored, err := s.saveAudioFile(ctx, path, name)
+	if err != nil {
+		return storedAudio{}, err
+	}
+	stored.Loudness = loudness
+	return stored, nil
+}
+
+func (s *server) downloadToTemp(ctx context.Context, name string) (string, error) {
+	content, _, err := s.store.Get(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	tmp, err := os.CreateTemp(s.ffmpeg.tempDir, "source-*"+filepath.Ext(name))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(tmp, content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 )
 
+const refreshCookieName = "refresh_token"
+
 func (s *server) authDiscordHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "solo se permite GET", http.StatusMethodNotAllowed)
@@ -60,13 +64,14 @@ func (s *server) authCallbackHandler(w http.ResponseWriter, r *http.Request) {
 		Path:   "/",
 	})
 
-	accessToken, err := s.auth.exchangeCodeForToken(code)
+	oauthToken, err := s.auth.exchangeCodeForToken(code)
 	if err != nil {
 		log.Printf("error al intercambiar código: %v", err)
 		http.Error(w, "error al autenticar con Discord", http.StatusUnauthorized)
 		return
 	}
 
+	accessToken := oauthToken.AccessToken
 	user, err := s.auth.fetchDiscordUser(accessToken)
 	if err != nil {
 		log.Printf("error al obtener usuario: %v", err)
@@ -81,44 +86,157 @@ func (s *server) authCallbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !isMember {
+		s.membership.set(user.ID, memberLeft)
 		http.Error(w, "debes ser miembro del servidor de Discord para usar Wasabi", http.StatusForbidden)
 		return
 	}
 
-	jwtToken, err := s.auth.generateJWT(user)
+	discordRoles, err := s.auth.fetchGuildMemberRoles(accessToken)
+	if err != nil {
+		log.Printf("error al obtener roles del servidor: %v", err)
+		http.Error(w, "no se pudieron obtener tus roles del servidor", http.StatusUnauthorized)
+		return
+	}
+	role := s.auth.resolveRole(user.ID, discordRoles)
+	if err := s.capUserRole(r.Context(), user.ID, role, ""); err != nil {
+		log.Printf("%v", err)
+	}
+	s.membership.set(user.ID, memberActive)
+
+	sess, err := s.createSession(r.Context(), r, user, role, oauthToken)
 	if err != nil {
+		log.Printf("error al registrar sesión: %v", err)
+		http.Error(w, "error al crear sesión", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.setSessionCookies(w, sess); err != nil {
 		log.Printf("error al generar JWT: %v", err)
 		http.Error(w, "error al crear sesión", http.StatusInternalServerError)
 		return
 	}
 
+	http.Redirect(w, r, s.frontendOrigin, http.StatusTemporaryRedirect)
+}
+
+// refreshHandler canjea la cookie refresh_token por un access token nuevo y
+// el siguiente refresh token. No pasa por authRequired porque el access token
+// normalmente ya venció.
+func (s *server) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "solo se permite POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cookie, err := r.Cookie(refreshCookieName)
+	if err != nil {
+		http.Error(w, "no autenticado", http.StatusUnauthorized)
+		return
+	}
+
+	sess, err := s.refreshSession(r.Context(), cookie.Value)
+	switch {
+	case errors.Is(err, errRefreshRace):
+		// Otra pestaña ya renovó y el navegador tiene las cookies nuevas.
+		writeJSON(w, http.StatusOK, map[string]string{"message": errRefreshRace.Error()})
+		return
+	case errors.Is(err, errRefreshInvalid), errors.Is(err, errRefreshReused):
+		clearSessionCookies(w)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case errors.Is(err, errNotGuildMember):
+		clearSessionCookies(w)
+		http.Error(w, "debes ser miembro del servidor de Discord para usar Wasabi", http.StatusForbidden)
+		return
+	case err != nil:
+		log.Printf("error al renovar sesión: %v", err)
+		http.Error(w, "no se pudo renovar la sesión", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.setSessionCookies(w, sess); err != nil {
+		log.Printf("error al generar JWT: %v", err)
+		http.Error(w, "no se pudo renovar la sesión", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"message":          "sesión renovada",
+		"accessExpiresAt":  time.Now().Add(s.auth.accessTTL).UTC(),
+		"sessionExpiresAt": sess.ExpiresAt,
+	})
+}
+
+func (s *server) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "solo se permite POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Se revoca la sesión para que ni el access token ni el refresh token
+	// sirvan aunque alguien los haya copiado. Si el access token ya venció,
+	// la sesión se identifica por el refresh token.
+	sessionID := ""
+	if cookie, err := r.Cookie("auth_token"); err == nil {
+		if claims, err := s.auth.validateJWT(cookie.Value); err == nil {
+			sessionID = claims.ID
+		}
+	}
+	if cookie, err := r.Cookie(refreshCookieName); err == nil && sessionID == "" {
+		if id, _, err := s.auth.parseRefreshToken(cookie.Value); err == nil {
+			sessionID = id
+		}
+	}
+	if sessionID != "" {
+		s.revokeSessionByID(r.Context(), sessionID, "logout")
+	}
+
+	clearSessionCookies(w)
+	writeJSON(w, http.StatusOK, map[string]string{"message": "sesión cerrada"})
+}
+
+// setSessionCookies emite el access token y el refresh token de la
+// generación actual de la sesión. El refresh token solo viaja a /auth.
+func (s *server) setSessionCookies(w http.ResponseWriter, sess session) error {
+	jwtToken, err := s.auth.generateJWT(sess.user(), wasabiRole(sess.Role), sess.ID)
+	if err != nil {
+		return err
+	}
+
 	http.SetCookie(w, &http.Cookie{
 		Name:     "auth_token",
 		Value:    jwtToken,
 		HttpOnly: true,
 		Secure:   false,
 		SameSite: http.SameSiteLaxMode,
-		MaxAge:   86400,
+		MaxAge:   int(s.auth.accessTTL.Seconds()),
 		Path:     "/",
 	})
-
-	http.Redirect(w, r, s.frontendOrigin, http.StatusTemporaryRedirect)
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookieName,
+		Value:    s.auth.signRefreshToken(sess.ID, sess.RefreshGeneration),
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(time.Until(sess.ExpiresAt).Seconds()),
+		Path:     "/auth",
+	})
+	return nil
 }
 
-func (s *server) logoutHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "solo se permite POST", http.StatusMethodNotAllowed)
-		return
-	}
-
+func clearSessionCookies(w http.ResponseWriter) {
 	http.SetCookie(w, &http.Cookie{
 		Name:   "auth_token",
 		Value:  "",
 		MaxAge: -1,
 		Path:   "/",
 	})
-
-	writeJSON(w, http.StatusOK, map[string]string{"message": "sesión cerrada"})
+	http.SetCookie(w, &http.Cookie{
+		Name:   refreshCookieName,
+		Value:  "",
+		MaxAge: -1,
+		Path:   "/auth",
+	})
 }
 
 func (s *server) meHandler(w http.ResponseWriter, r *http.Request) {
@@ -133,10 +251,18 @@ func (s *server) meHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]interface{}{
+	response := map[string]interface{}{
 		"user_id":       claims.UserID,
 		"username":      claims.Username,
 		"discriminator": claims.Discriminator,
 		"avatar":        claims.Avatar,
-	})
+		"role":          s.auth.roleOf(claims),
+	}
+
+	// Le dice al frontend cuándo renovar antes de que venza el access token.
+	if claims.TokenID == "" && claims.ExpiresAt != nil {
+		response["accessExpiresAt"] = claims.ExpiresAt.UTC()
+	}
+
+	writeJSON(w, http.StatusOK, response)
 }
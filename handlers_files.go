@@ -1,69 +1,122 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 type fileEntry struct {
-	Name     string `json:"name"`
-	Size     int64  `json:"size"`
-	Modified string `json:"modified"`
+	Name           string          `json:"name"`
+	Title          string          `json:"title"`
+	Size           int64           `json:"size"`
+	Modified       string          `json:"modified"`
+	UploadedAt     string          `json:"uploadedAt"`
+	UploaderID     string          `json:"uploaderId,omitempty"`
+	UploaderName   string          `json:"uploaderName,omitempty"`
+	OriginalName   string          `json:"originalName,omitempty"`
+	OriginalFormat string          `json:"originalFormat,omitempty"`
+	DetectedFormat string          `json:"detectedFormat,omitempty"`
+	Duration       float64         `json:"duration"`
+	SampleRate     int             `json:"sampleRate,omitempty"`
+	Channels       int             `json:"channels,omitempty"`
+	Bitrate        int64           `json:"bitrate,omitempty"`
+	Codec          string          `json:"codec,omitempty"`
+	Checksum       string          `json:"checksum,omitempty"`
+	Loudness       *loudnessRecord `json:"loudness,omitempty"`
 }
 
 type renameRequest struct {
 	NewName string `json:"newName"`
+	Title   string `json:"title"`
 }
 
-var allowedUploadExts = map[string]bool{
-	".mp3": true,
-	".ogg": true,
-	".wav": true,
-	".m4a": true,
+type storedAudio struct {
+	Size     int64
+	Checksum string
+	Audio    audioInfo
+	Loudness *loudnessRecord
+	Peaks    []float64
 }
 
+// multipartOverhead deja margen para cabeceras y campos del formulario por
+// encima del tamaño máximo del archivo.
+const multipartOverhead = 1 << 20
+
 func (s *server) uploadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "solo se permite POST", http.StatusMethodNotAllowed)
 		return
 	}
 
-	if err := r.ParseMultipartForm(32 << 20); err != nil {
-		http.Error(w, "no se pudo procesar el formulario", http.StatusBadRequest)
+	claims, ok := getUserClaims(r.Context())
+	if !ok {
+		http.Error(w, "no se pudo obtener usuario", http.StatusInternalServerError)
 		return
 	}
 
-	file, header, err := r.FormFile("file")
+	// La petición completa se acota al máximo de una subida múltiple; cada
+	// archivo se corta en su propio límite mientras se lee.
+	r.Body = http.MaxBytesReader(w, r.Body, max(s.uploadPolicy.MaxFileSize, s.uploadPolicy.MaxBatchSize)+multipartOverhead)
+
+	parts, fields, err := s.readUploadForm(r)
 	if err != nil {
-		http.Error(w, "archivo requerido con campo 'file'", http.StatusBadRequest)
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			if s.uploadPolicy.MaxBatchSize > s.uploadPolicy.MaxFileSize {
+				writePolicyRejection(w, s.uploadPolicy.batchTooLarge(r.ContentLength))
+			} else {
+				writePolicyRejection(w, s.uploadPolicy.fileTooLarge(r.ContentLength))
+			}
+		case errors.Is(err, errInvalidForm), errors.Is(err, io.ErrUnexpectedEOF):
+			http.Error(w, "no se pudo procesar el formulario", http.StatusBadRequest)
+		default:
+			log.Printf("error al guardar subida: %v", err)
+			http.Error(w, "no se pudo guardar el archivo", http.StatusInternalServerError)
+		}
 		return
 	}
-	defer file.Close()
+	defer removeUploadParts(parts)
 
-	fileName := header.Filename
-	if custom := strings.TrimSpace(r.FormValue("filename")); custom != "" {
-		fileName = custom
+	if len(parts) == 0 {
+		http.Error(w, "archivo requerido con campo 'file'", http.StatusBadRequest)
+		return
 	}
-
-	originalExt := strings.ToLower(filepath.Ext(header.Filename))
-	if originalExt == "" {
-		originalExt = strings.ToLower(filepath.Ext(fileName))
+	if len(parts) > 1 || parts[0].Zip {
+		s.uploadBatch(w, r, claims, parts)
+		return
 	}
-	if !allowedUploadExts[originalExt] {
-		http.Error(w, "formato no soportado, usa mp3, ogg, wav o m4a", http.StatusBadRequest)
+
+	part := parts[0]
+	if part.TooLarge {
+		writePolicyRejection(w, s.uploadPolicy.fileTooLarge(part.Size))
 		return
 	}
 
+	fileName := part.FileName
+	if custom := strings.TrimSpace(fields["filename"]); custom != "" {
+		fileName = custom
+	}
+
 	safeName, err := sanitizeName(fileName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -71,35 +124,133 @@ func (s *server) uploadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	finalName := ensureMP3Name(safeName)
-	dstPath := filepath.Join(s.uploadDir, finalName)
-	if _, err := os.Stat(dstPath); err == nil {
+	taken, err := s.targetTaken(r.Context(), finalName)
+	if err != nil {
+		log.Printf("error al verificar destino: %v", err)
+		http.Error(w, "no se pudo guardar el archivo", http.StatusInternalServerError)
+		return
+	}
+	if taken {
 		http.Error(w, "ya existe un archivo con ese nombre", http.StatusConflict)
 		return
 	}
 
-	if originalExt == ".mp3" {
-		dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	res, err := s.ingestUpload(r.Context(), ingestRequest{
+		SourcePath:     part.Path,
+		SourceChecksum: part.Checksum,
+		OriginalName:   part.FileName,
+		TargetName:     finalName,
+		Title:          fields["title"],
+		Claims:         claims,
+	})
+	if err != nil {
+		s.writeIngestError(w, part.FileName, err)
+		return
+	}
+
+	writeIngestResult(w, res)
+}
+
+// maxFormField acota los campos de texto del formulario de subida.
+const maxFormField = 4 << 10
+
+var errInvalidForm = errors.New("formulario de subida inválido")
+
+// uploadPart es un campo 'file' ya guardado en la carpeta de trabajos. Si
+// superó su límite, TooLarge queda en true, Size es lo que se llegó a leer y
+// no hay archivo.
+type uploadPart struct {
+	FileName string
+	Path     string
+	Checksum string
+	Size     int64
+	Zip      bool
+	TooLarge bool
+}
+
+// readUploadForm lee el multipart parte por parte. Un audio se corta en
+// MaxFileSize y un ZIP en MaxBatchSize, así ninguno llega a disco entero si
+// se pasa. Ante un error borra lo que ya había guardado.
+func (s *server) readUploadForm(r *http.Request) ([]uploadPart, map[string]string, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errInvalidForm, err)
+	}
+
+	var parts []uploadPart
+	fields := make(map[string]string)
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return parts, fields, nil
+		}
 		if err != nil {
-			log.Printf("error al abrir destino: %v", err)
-			http.Error(w, "no se pudo guardar el archivo", http.StatusInternalServerError)
-			return
+			removeUploadParts(parts)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return nil, nil, err
+			}
+			return nil, nil, fmt.Errorf("%w: %v", errInvalidForm, err)
 		}
-		defer dst.Close()
 
-		if _, err := io.Copy(dst, file); err != nil {
-			log.Printf("error al copiar archivo: %v", err)
-			http.Error(w, "no se pudo escribir el archivo", http.StatusInternalServerError)
-			return
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormField))
+			if err != nil {
+				removeUploadParts(parts)
+				return nil, nil, err
+			}
+			fields[part.FormName()] = string(value)
+			continue
 		}
-	} else {
-		if err := s.convertAndSaveAsMP3(file, originalExt, dstPath); err != nil {
-			log.Printf("error al convertir a mp3: %v", err)
-			http.Error(w, "no se pudo convertir el archivo a mp3", http.StatusInternalServerError)
-			return
+		if part.FormName() != "file" {
+			continue
+		}
+
+		spooled, err := s.spoolUploadPart(part)
+		if err != nil {
+			removeUploadParts(parts)
+			return nil, nil, err
 		}
+		parts = append(parts, spooled)
 	}
+}
 
-	writeJSON(w, http.StatusCreated, map[string]string{"message": "archivo subido", "name": finalName})
+func (s *server) spoolUploadPart(part *multipart.Part) (uploadPart, error) {
+	br := bufio.NewReader(part)
+	head, _ := br.Peek(len(zipMagic))
+	out := uploadPart{FileName: part.FileName(), Zip: bytes.Equal(head, zipMagic)}
+
+	limit := s.uploadPolicy.MaxFileSize
+	if out.Zip {
+		limit = s.uploadPolicy.MaxBatchSize
+	}
+	path, checksum, err := s.spoolUpload(io.LimitReader(br, limit+1))
+	if err != nil {
+		return uploadPart{}, err
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		os.Remove(path)
+		return uploadPart{}, err
+	}
+
+	out.Size = stat.Size()
+	if out.Size > limit {
+		// El resto de la parte lo descarta NextPart.
+		os.Remove(path)
+		out.TooLarge = true
+		return out, nil
+	}
+	out.Path, out.Checksum = path, checksum
+	return out, nil
+}
+
+func removeUploadParts(parts []uploadPart) {
+	for _, part := range parts {
+		if part.Path != "" {
+			os.Remove(part.Path)
+		}
+	}
 }
 
 func (s *server) listHandler(w http.ResponseWriter, r *http.Request) {
@@ -108,36 +259,59 @@ func (s *server) listHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	entries, err := os.ReadDir(s.uploadDir)
+	filter, err := soundFilterFromQuery(r.URL.Query())
 	if err != nil {
-		log.Printf("error al leer carpeta: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	records, err := s.findSounds(ctx, filter)
+	if err != nil {
+		log.Printf("error al leer catálogo: %v", err)
 		http.Error(w, "no se pudo listar archivos", http.StatusInternalServerError)
 		return
 	}
 
-	var files []fileEntry
-	for _, e := range entries {
-		if e.IsDir() {
+	files := make([]fileEntry, 0, len(records))
+	for _, rec := range records {
+		files = append(files, rec.entry())
+	}
+
+	writeJSON(w, http.StatusOK, files)
+}
+
+func soundFilterFromQuery(query url.Values) (bson.M, error) {
+	filter := readySounds(bson.M{})
+
+	duration := bson.M{}
+	for param, op := range map[string]string{"minDuration": "$gte", "maxDuration": "$lte"} {
+		raw := strings.TrimSpace(query.Get(param))
+		if raw == "" {
 			continue
 		}
-		info, err := e.Info()
-		if err != nil {
-			log.Printf("error al leer info de archivo %s: %v", e.Name(), err)
-			continue
+		seconds, err := strconv.ParseFloat(raw, 64)
+		if err != nil || seconds < 0 {
+			return nil, fmt.Errorf("%s debe ser un número de segundos", param)
 		}
-		files = append(files, fileEntry{
-			Name:     e.Name(),
-			Size:     info.Size(),
-			Modified: info.ModTime().Format(time.RFC3339),
-		})
+		duration[op] = seconds
+	}
+	if len(duration) > 0 {
+		filter["duration"] = duration
 	}
 
-	writeJSON(w, http.StatusOK, files)
+	if codec := strings.TrimSpace(query.Get("codec")); codec != "" {
+		filter["codec"] = codec
+	}
+
+	return filter, nil
 }
 
 func (s *server) fileHandler(w http.ResponseWriter, r *http.Request) {
-	name := strings.TrimPrefix(r.URL.Path, "/files/")
-	if name == "" || strings.Contains(name, "/") {
+	name, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/files/"), "/")
+	if name == "" || strings.Contains(action, "/") {
 		http.Error(w, "ruta inválida", http.StatusBadRequest)
 		return
 	}
@@ -148,66 +322,122 @@ func (s *server) fileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if action != "" {
+		s.fileActionHandler(w, r, currentName, action)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		s.serveFile(w, r, currentName)
 	case http.MethodDelete:
-		s.deleteFile(w, r, currentName)
+		if s.authorizeSoundChange(w, r, currentName) {
+			s.deleteFile(w, r, currentName)
+		}
 	case http.MethodPut:
-		s.renameFile(w, r, currentName)
+		if s.authorizeSoundChange(w, r, currentName) {
+			s.renameFile(w, r, currentName)
+		}
 	default:
 		http.Error(w, "método no permitido", http.StatusMethodNotAllowed)
 	}
 }
 
+func (s *server) authorizeSoundChange(w http.ResponseWriter, r *http.Request, name string) bool {
+	claims, ok := getUserClaims(r.Context())
+	if !ok {
+		http.Error(w, "no se pudo obtener usuario", http.StatusInternalServerError)
+		return false
+	}
+
+	rec, err := s.findSound(r.Context(), name)
+	if err != nil && !errors.Is(err, errSoundNotFound) {
+		log.Printf("error al consultar catálogo: %v", err)
+		http.Error(w, "no se pudo verificar permisos", http.StatusInternalServerError)
+		return false
+	}
+
+	if !s.canManageSound(claims, rec) {
+		log.Printf("cambio denegado: user_id=%s method=%s sound=%s uploader=%s", claims.UserID, r.Method, name, rec.UploaderID)
+		http.Error(w, "solo quien subió el sonido o un moderador puede modificarlo", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
 func (s *server) serveFile(w http.ResponseWriter, r *http.Request, name string) {
-	path := filepath.Join(s.uploadDir, name)
-	info, err := os.Stat(path)
-	if errors.Is(err, os.ErrNotExist) {
+	format, err := negotiateRendition(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+	if format.Name != renditionMP3.Name {
+		s.serveRendition(w, r, name, format)
+		return
+	}
+
+	content, obj, err := s.store.Get(r.Context(), name)
+	if errors.Is(err, errObjectNotFound) {
 		http.Error(w, "archivo no encontrado", http.StatusNotFound)
 		return
 	}
+	if errors.Is(err, errObjectInvalid) {
+		http.Error(w, "nombre inválido", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		log.Printf("error al acceder a archivo: %v", err)
 		http.Error(w, "no se pudo abrir el archivo", http.StatusInternalServerError)
 		return
 	}
-	if info.IsDir() {
-		http.Error(w, "nombre inválido", http.StatusBadRequest)
+	defer content.Close()
+
+	if seeker, ok := content.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, name, obj.Modified, seeker)
 		return
 	}
 
-	http.ServeFile(w, r, path)
+	if ctype := mime.TypeByExtension(filepath.Ext(name)); ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	}
+	if obj.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+	}
+	if !obj.Modified.IsZero() {
+		w.Header().Set("Last-Modified", obj.Modified.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, content); err != nil {
+		log.Printf("error al enviar archivo %s: %v", name, err)
+	}
 }
 
 func (s *server) deleteFile(w http.ResponseWriter, r *http.Request, name string) {
-	path := filepath.Join(s.uploadDir, name)
-	info, err := os.Stat(path)
-	if errors.Is(err, os.ErrNotExist) {
-		http.Error(w, "archivo no encontrado", http.StatusNotFound)
+	claims, ok := getUserClaims(r.Context())
+	if !ok {
+		http.Error(w, "no se pudo obtener usuario", http.StatusInternalServerError)
 		return
 	}
-	if err != nil {
-		log.Printf("error al acceder a archivo: %v", err)
-		http.Error(w, "no se pudo eliminar el archivo", http.StatusInternalServerError)
+
+	err := s.moveToTrash(r.Context(), name, claims)
+	if errors.Is(err, errObjectNotFound) {
+		http.Error(w, "archivo no encontrado", http.StatusNotFound)
 		return
 	}
-	if info.IsDir() {
+	if errors.Is(err, errObjectInvalid) {
 		http.Error(w, "nombre inválido", http.StatusBadRequest)
 		return
 	}
-
-	if err := os.Remove(path); err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			http.Error(w, "archivo no encontrado", http.StatusNotFound)
-			return
-		}
+	if err != nil {
 		log.Printf("error al eliminar archivo: %v", err)
 		http.Error(w, "no se pudo eliminar el archivo", http.StatusInternalServerError)
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]string{"message": "archivo eliminado", "name": name})
+	log.Printf("archivo enviado a la papelera: user_id=%s username=%s sound=%s", claims.UserID, claims.Username, name)
+	writeJSON(w, http.StatusOK, map[string]string{"message": "archivo enviado a la papelera", "name": name})
 }
 
 func (s *server) renameFile(w http.ResponseWriter, r *http.Request, currentName string) {
@@ -223,68 +453,167 @@ func (s *server) renameFile(w http.ResponseWriter, r *http.Request, currentName
 		return
 	}
 
+	title := strings.TrimSpace(payload.Title)
 	if newName == currentName {
+		if title != "" {
+			if err := s.renameSound(r.Context(), currentName, currentName, title); err != nil && !errors.Is(err, errSoundNotFound) {
+				log.Printf("error al actualizar título: %v", err)
+				http.Error(w, "no se pudo actualizar el título", http.StatusInternalServerError)
+				return
+			}
+		}
 		writeJSON(w, http.StatusOK, map[string]string{"message": "archivo sin cambios", "name": newName})
 		return
 	}
 
-	oldPath := filepath.Join(s.uploadDir, currentName)
-	if _, err := os.Stat(oldPath); errors.Is(err, os.ErrNotExist) {
-		http.Error(w, "archivo no encontrado", http.StatusNotFound)
+	rec, err := s.findSound(r.Context(), currentName)
+	catalogued := err == nil
+	if err != nil && !errors.Is(err, errSoundNotFound) {
+		log.Printf("error al consultar catálogo: %v", err)
+		http.Error(w, "no se pudo renombrar el archivo", http.StatusInternalServerError)
+		return
+	}
+	if catalogued && rec.Status == soundProcessing {
+		http.Error(w, "el sonido todavía se está procesando", http.StatusConflict)
 		return
 	}
 
-	newPath := filepath.Join(s.uploadDir, newName)
-	if _, err := os.Stat(newPath); err == nil {
+	taken, err := s.targetTaken(r.Context(), newName)
+	if err != nil {
+		log.Printf("error al verificar destino: %v", err)
+		http.Error(w, "no se pudo renombrar el archivo", http.StatusInternalServerError)
+		return
+	}
+	if taken {
 		http.Error(w, "ya existe un archivo con el nuevo nombre", http.StatusConflict)
 		return
 	}
 
-	if err := os.Rename(oldPath, newPath); err != nil {
+	// El catálogo va primero: su índice único reserva el nuevo nombre frente a
+	// subidas y trabajos que lleguen mientras se mueve el archivo.
+	if catalogued {
+		err := s.renameSound(r.Context(), currentName, newName, title)
+		if errors.Is(err, errSoundExists) {
+			http.Error(w, "ya existe un archivo con el nuevo nombre", http.StatusConflict)
+			return
+		}
+		if errors.Is(err, errSoundNotFound) {
+			http.Error(w, "archivo no encontrado", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("error al renombrar %s en catálogo: %v", currentName, err)
+			http.Error(w, "no se pudo renombrar el archivo", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = s.store.Rename(r.Context(), currentName, newName)
+	if err != nil && catalogued {
+		if err := s.renameSound(r.Context(), newName, currentName, rec.Title); err != nil {
+			log.Printf("no se pudo deshacer el renombre de %s en catálogo: %v", currentName, err)
+		}
+	}
+	if errors.Is(err, errObjectExists) {
+		http.Error(w, "ya existe un archivo con el nuevo nombre", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, errObjectNotFound) {
+		http.Error(w, "archivo no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
 		log.Printf("error al renombrar archivo: %v", err)
 		http.Error(w, "no se pudo renombrar el archivo", http.StatusInternalServerError)
 		return
 	}
 
+	if claims, ok := getUserClaims(r.Context()); ok {
+		log.Printf("archivo renombrado: user_id=%s username=%s sound=%s new_name=%s", claims.UserID, claims.Username, currentName, newName)
+	}
+
 	writeJSON(w, http.StatusOK, map[string]string{"message": "archivo renombrado", "name": newName})
 }
 
-func (s *server) convertAndSaveAsMP3(src io.Reader, sourceExt, dstPath string) error {
-	tmpIn, err := os.CreateTemp("", "upload-*"+sourceExt)
+func (s *server) convertAndSaveAsMP3(ctx context.Context, inputPath, sourceExt, dstName string) (storedAudio, error) {
+	if s.loudnorm.Enabled {
+		return s.normalizeAndSave(ctx, inputPath, dstName)
+	}
+
+	outPath := inputPath
+	if sourceExt != ".mp3" {
+		tmpOut, err := s.ffmpeg.tempFile(".tmp-convert-*.mp3")
+		if err != nil {
+			return storedAudio{}, err
+		}
+		outPath = tmpOut
+		defer os.Remove(outPath)
+
+		if err := s.ffmpeg.convertToMP3(ctx, inputPath, outPath); err != nil {
+			return storedAudio{}, err
+		}
+	}
+
+	return s.saveAudioFile(ctx, outPath, dstName)
+}
+
+func (s *server) normalizeAndSave(ctx context.Context, inputPath, dstName string) (storedAudio, error) {
+	outPath, err := s.ffmpeg.tempFile(".tmp-convert-*.mp3")
 	if err != nil {
-		return fmt.Errorf("no se pudo preparar el archivo temporal: %w", err)
+		return storedAudio{}, err
 	}
-	defer os.Remove(tmpIn.Name())
+	defer os.Remove(outPath)
 
-	if _, err := io.Copy(tmpIn, src); err != nil {
-		tmpIn.Close()
-		return fmt.Errorf("no se pudo guardar el archivo temporal: %w", err)
+	loudness, err := s.ffmpeg.normalizeToMP3(ctx, inputPath, outPath, s.loudnorm.Target)
+	if err != nil {
+		return storedAudio{}, err
 	}
-	if err := tmpIn.Close(); err != nil {
-		return fmt.Errorf("no se pudo cerrar el archivo temporal: %w", err)
+
+	stored, err := s.saveAudioFile(ctx, outPath, dstName)
+	if err != nil {
+		return storedAudio{}, err
+	}
+	stored.Loudness = &loudness
+	return stored, nil
+}
+
+func (s *server) saveAudioFile(ctx context.Context, path, dstName string) (storedAudio, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return storedAudio{}, fmt.Errorf("no se pudo abrir el mp3: %w", err)
 	}
+	defer f.Close()
 
-	tmpOut, err := os.CreateTemp(s.uploadDir, ".tmp-convert-*.mp3")
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
 	if err != nil {
-		return fmt.Errorf("no se pudo preparar el destino temporal: %w", err)
+		return storedAudio{}, fmt.Errorf("no se pudo leer el mp3: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return storedAudio{}, fmt.Errorf("no se pudo leer el mp3: %w", err)
 	}
-	tmpOutPath := tmpOut.Name()
-	tmpOut.Close()
-	defer os.Remove(tmpOutPath)
 
-	if err := convertToMP3(tmpIn.Name(), tmpOutPath); err != nil {
-		return err
+	info, err := s.ffmpeg.probeAudio(ctx, path)
+	if err != nil {
+		return storedAudio{}, fmt.Errorf("no se pudo analizar el mp3 resultante: %w", err)
 	}
 
-	if err := os.Rename(tmpOutPath, dstPath); err != nil {
-		return fmt.Errorf("no se pudo mover el mp3 convertido: %w", err)
+	// Sin forma de onda el sonido sigue siendo usable; se genera al pedirla.
+	peaks, err := s.ffmpeg.waveformPeaks(ctx, path, info.Duration)
+	if err != nil {
+		log.Printf("no se pudo generar forma de onda de %s: %v", dstName, err)
 	}
 
-	if err := os.Chmod(dstPath, 0o644); err != nil {
-		return fmt.Errorf("no se pudieron ajustar permisos: %w", err)
+	if err := s.store.Put(ctx, dstName, f); err != nil {
+		return storedAudio{}, fmt.Errorf("no se pudo guardar el mp3: %w", err)
 	}
 
-	return nil
+	return storedAudio{
+		Size:     size,
+		Checksum: hex.EncodeToString(hash.Sum(nil)),
+		Audio:    info,
+		Peaks:    peaks,
+	}, nil
 }
 
 func sanitizeName(name string) (string, error) {
@@ -309,18 +638,6 @@ func ensureMP3Name(name string) string {
 	return base + ".mp3"
 }
 
-func convertToMP3(inputPath, outputPath string) error {
-	var stderr bytes.Buffer
-	cmd := exec.Command("ffmpeg", "-y", "-i", inputPath, "-vn", "-codec:a", "libmp3lame", "-qscale:a", "2", outputPath)
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("ffmpeg no pudo convertir el archivo: %v: %s", err, stderr.String())
-	}
-
-	return nil
-}
-
 func writeJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+func (s *server) allowsFormat(format audioFormat) bool {
+	for _, allowed := range s.allowedFormats {
+		if allowed.Name == format.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsExtension solo sirve para elegir qué entradas de un ZIP intentar; el
+// formato real se decide después por el contenido.
+func (s *server) allowsExtension(ext string) bool {
+	ext = strings.ToLower(ext)
+	for _, format := range s.allowedFormats {
+		for _, allowed := range format.Extensions {
+			if allowed == ext {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *server) allowedFormatNames() string {
+	names := make([]string, 0, len(s.allowedFormats))
+	for _, format := range s.allowedFormats {
+		names = append(names, format.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// formatsHandler publica los formatos aceptados para que el frontend arme el
+// atributo accept del selector de archivos.
+func (s *server) formatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "solo se permite GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var accept []string
+	for _, format := range s.allowedFormats {
+		accept = append(accept, format.Extensions...)
+		accept = append(accept, format.MimeTypes...)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"formats": s.allowedFormats,
+		"accept":  strings.Join(accept, ","),
+	})
+}
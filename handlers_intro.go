@@ -6,7 +6,6 @@ import (
 	"errors"
 	"log"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -43,21 +42,20 @@ func (s *server) introHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	path := filepath.Join(s.uploadDir, soundName)
-	info, err := os.Stat(path)
-	if errors.Is(err, os.ErrNotExist) {
+	_, err = s.store.Stat(r.Context(), soundName)
+	if errors.Is(err, errObjectNotFound) {
 		http.Error(w, "el sonido no existe en uploads", http.StatusNotFound)
 		return
 	}
+	if errors.Is(err, errObjectInvalid) {
+		http.Error(w, "nombre de sonido inválido", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		log.Printf("error al validar sonido: %v", err)
 		http.Error(w, "no se pudo procesar la solicitud", http.StatusInternalServerError)
 		return
 	}
-	if info.IsDir() {
-		http.Error(w, "nombre de sonido inválido", http.StatusBadRequest)
-		return
-	}
 
 	effect := strings.TrimSuffix(soundName, filepath.Ext(soundName))
 	if effect == "" {
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (s *server) jobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "solo se permite GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "ruta inválida", http.StatusBadRequest)
+		return
+	}
+
+	claims, ok := getUserClaims(r.Context())
+	if !ok {
+		http.Error(w, "no se pudo obtener usuario", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	job, err := s.findJob(ctx, id)
+	if errors.Is(err, errJobNotFound) {
+		http.Error(w, "trabajo no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error al leer trabajo %s: %v", id, err)
+		http.Error(w, "no se pudo consultar el trabajo", http.StatusInternalServerError)
+		return
+	}
+
+	if job.UserID != claims.UserID && !s.auth.isElevated(claims) {
+		http.Error(w, "solo quien subió el archivo o un moderador puede ver este trabajo", http.StatusForbidden)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job)
+}
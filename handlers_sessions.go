@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// sessionsHandler lista las sesiones activas del usuario (GET) o cierra todas
+// menos la actual (DELETE).
+func (s *server) sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := getUserClaims(r.Context())
+	if !ok {
+		http.Error(w, "no se pudo obtener usuario", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sessions, err := s.listSessions(r.Context(), claims.UserID, claims.ID)
+		if err != nil {
+			log.Printf("error al listar sesiones: %v", err)
+			http.Error(w, "no se pudieron listar las sesiones", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"sessions": sessions})
+	case http.MethodDelete:
+		revoked, err := s.revokeUserSessions(r.Context(), claims.UserID, claims.ID, "user")
+		if err != nil {
+			log.Printf("error al revocar sesiones: %v", err)
+			http.Error(w, "no se pudieron cerrar las sesiones", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("sesiones cerradas: user_id=%s cantidad=%d", claims.UserID, revoked)
+		writeJSON(w, http.StatusOK, map[string]any{"message": "sesiones cerradas", "revoked": revoked})
+	default:
+		http.Error(w, "método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// sessionHandler cierra una sesión propia con DELETE /auth/sessions/{id}.
+func (s *server) sessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "solo se permite DELETE", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, ok := getUserClaims(r.Context())
+	if !ok {
+		http.Error(w, "no se pudo obtener usuario", http.StatusInternalServerError)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/auth/sessions/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "ruta inválida", http.StatusBadRequest)
+		return
+	}
+
+	err := s.revokeSession(r.Context(), claims.UserID, id, "user")
+	if errors.Is(err, errSessionNotFound) {
+		http.Error(w, "sesión no encontrada", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error al revocar sesión: %v", err)
+		http.Error(w, "no se pudo cerrar la sesión", http.StatusInternalServerError)
+		return
+	}
+
+	if id == claims.ID {
+		clearSessionCookies(w)
+	}
+
+	log.Printf("sesión cerrada: user_id=%s session_id=%s", claims.UserID, id)
+	writeJSON(w, http.StatusOK, map[string]string{"message": "sesión cerrada", "id": id})
+}
+
+// userSessionsHandler corta el acceso de un usuario con
+// DELETE /admin/users/{id}/sessions: revoca sus sesiones y borra sus tokens
+// de API, por ejemplo cuando dejó el servidor de Discord.
+func (s *server) userSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/admin/users/"), "/sessions")
+	if !ok || userID == "" || strings.Contains(userID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "solo se permite DELETE", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessions, err := s.revokeUserSessions(r.Context(), userID, "", "admin")
+	if err != nil {
+		log.Printf("error al revocar sesiones de %s: %v", userID, err)
+		http.Error(w, "no se pudieron cerrar las sesiones", http.StatusInternalServerError)
+		return
+	}
+	tokens, err := s.revokeUserAPITokens(r.Context(), userID)
+	if err != nil {
+		log.Printf("error al revocar tokens de %s: %v", userID, err)
+		http.Error(w, "no se pudieron revocar los tokens", http.StatusInternalServerError)
+		return
+	}
+
+	admin, _ := getUserClaims(r.Context())
+	log.Printf("acceso revocado: user_id=%s sesiones=%d tokens=%d por=%s", userID, sessions, tokens, admin.UserID)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"message":         "acceso revocado",
+		"userId":          userID,
+		"sessionsRevoked": sessions,
+		"tokensRevoked":   tokens,
+	})
+}
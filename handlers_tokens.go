@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type createTokenRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn string   `json:"expiresIn"`
+}
+
+// tokensHandler lista (GET) o crea (POST) los tokens de API del usuario.
+func (s *server) tokensHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := getUserClaims(r.Context())
+	if !ok {
+		http.Error(w, "no se pudo obtener usuario", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		tokens, err := s.listAPITokens(r.Context(), claims.UserID)
+		if err != nil {
+			log.Printf("error al listar tokens: %v", err)
+			http.Error(w, "no se pudieron listar los tokens", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"tokens": tokens})
+	case http.MethodPost:
+		s.createTokenHandler(w, r, claims)
+	default:
+		http.Error(w, "método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *server) createTokenHandler(w http.ResponseWriter, r *http.Request, claims *jwtClaims) {
+	var payload createTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "cuerpo JSON inválido", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(payload.Name)
+	if name == "" || len(name) > 100 {
+		http.Error(w, "nombre requerido (máximo 100 caracteres)", http.StatusBadRequest)
+		return
+	}
+
+	scopes, err := parseScopes(payload.Scopes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ttl time.Duration
+	if raw := strings.TrimSpace(payload.ExpiresIn); raw != "" {
+		ttl, err = time.ParseDuration(raw)
+		if err != nil || ttl <= 0 {
+			http.Error(w, "expiresIn debe ser una duración positiva (ej: 720h)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	plain, token, err := s.createAPIToken(r.Context(), claims, name, scopes, ttl)
+	if err != nil {
+		log.Printf("error al crear token: %v", err)
+		http.Error(w, "no se pudo crear el token", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("token de API creado: user_id=%s token_id=%s scopes=%s", claims.UserID, token.ID, strings.Join(scopes, ","))
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"token":   plain,
+		"details": token,
+		"message": "guarda el token ahora, no se vuelve a mostrar",
+	})
+}
+
+// tokenHandler revoca un token propio con DELETE /auth/tokens/{id}.
+func (s *server) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "solo se permite DELETE", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, ok := getUserClaims(r.Context())
+	if !ok {
+		http.Error(w, "no se pudo obtener usuario", http.StatusInternalServerError)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/auth/tokens/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "ruta inválida", http.StatusBadRequest)
+		return
+	}
+
+	err := s.revokeAPIToken(r.Context(), claims.UserID, id)
+	if errors.Is(err, errTokenNotFound) {
+		http.Error(w, "token no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error al revocar token: %v", err)
+		http.Error(w, "no se pudo revocar el token", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("token de API revocado: user_id=%s token_id=%s", claims.UserID, id)
+	writeJSON(w, http.StatusOK, map[string]string{"message": "token revocado", "id": id})
+}
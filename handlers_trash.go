@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (s *server) trashListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "solo se permite GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	entries, err := s.listTrash(ctx)
+	if err != nil {
+		log.Printf("error al leer papelera: %v", err)
+		http.Error(w, "no se pudo listar la papelera", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]trashItem, 0, len(entries))
+	for _, entry := range entries {
+		items = append(items, entry.item())
+	}
+
+	writeJSON(w, http.StatusOK, items)
+}
+
+func (s *server) trashItemHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/trash/")
+	name, action, ok := strings.Cut(rest, "/")
+	if !ok || action != "restore" || name == "" {
+		http.Error(w, "ruta inválida", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "solo se permite POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	safeName, err := sanitizeName(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Con varias entradas del mismo nombre, ?id= elige una; si no, la última.
+	id := r.URL.Query().Get("id")
+	rec, err := s.restoreFromTrash(r.Context(), safeName, id)
+	if errors.Is(err, errTrashNotFound) {
+		http.Error(w, "el sonido no está en la papelera", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errSoundExists) {
+		http.Error(w, "ya existe un archivo con ese nombre", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("error al restaurar %s: %v", safeName, err)
+		http.Error(w, "no se pudo restaurar el archivo", http.StatusInternalServerError)
+		return
+	}
+
+	if claims, ok := getUserClaims(r.Context()); ok {
+		log.Printf("archivo restaurado: user_id=%s username=%s sound=%s", claims.UserID, claims.Username, safeName)
+	}
+
+	writeJSON(w, http.StatusOK, rec.entry())
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tusDiscovery deja responder el OPTIONS de tus sin autenticación, como
+// espera el protocolo; el resto de métodos pasa por next.
+func (s *server) tusDiscovery(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			s.tusHandler(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// tusHandler implementa el protocolo tus 1.0 para subidas reanudables. Al
+// completarse, la subida pasa por la misma validación y cola que /upload.
+func (s *server) tusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		if s.uploadPolicy.MaxFileSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(s.uploadPolicy.MaxFileSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "versión de tus no soportada", http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, tusBasePath)
+	if id == "" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "método no permitido", http.StatusMethodNotAllowed)
+			return
+		}
+		s.tusCreate(w, r)
+		return
+	}
+	if strings.Contains(id, "/") || filepath.Base(id) != id {
+		http.Error(w, "ruta inválida", http.StatusBadRequest)
+		return
+	}
+
+	claims, ok := getUserClaims(r.Context())
+	if !ok {
+		http.Error(w, "no se pudo obtener usuario", http.StatusInternalServerError)
+		return
+	}
+
+	unlock := s.tus.lock(id)
+	defer unlock()
+
+	upload, err := s.tus.load(id)
+	if errors.Is(err, errTusNotFound) {
+		http.Error(w, "subida no encontrada", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error al leer subida %s: %v", id, err)
+		http.Error(w, "no se pudo leer la subida", http.StatusInternalServerError)
+		return
+	}
+	if upload.UserID != claims.UserID {
+		http.Error(w, "la subida pertenece a otro usuario", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		w.Header().Set("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPatch:
+		s.tusPatch(w, r, &upload)
+	case http.MethodDelete:
+		s.tus.remove(id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *server) tusCreate(w http.ResponseWriter, r *http.Request) {
+	claims, ok := getUserClaims(r.Context())
+	if !ok {
+		http.Error(w, "no se pudo obtener usuario", http.StatusInternalServerError)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		http.Error(w, "Upload-Length requerido", http.StatusBadRequest)
+		return
+	}
+	if s.uploadPolicy.MaxFileSize > 0 && length > s.uploadPolicy.MaxFileSize {
+		writePolicyRejection(w, s.uploadPolicy.fileTooLarge(length))
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fileName := metadata["filename"]
+	if custom := strings.TrimSpace(metadata["name"]); custom != "" {
+		fileName = custom
+	}
+	safeName, err := sanitizeName(fileName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	target := ensureMP3Name(safeName)
+
+	// Se rechaza antes de recibir los datos; al terminar se vuelve a revisar.
+	exists, err := s.targetTaken(r.Context(), target)
+	if err != nil {
+		log.Printf("error al verificar destino: %v", err)
+		http.Error(w, "no se pudo crear la subida", http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		http.Error(w, "ya existe un archivo con ese nombre", http.StatusConflict)
+		return
+	}
+
+	id, err := newTusID()
+	if err != nil {
+		log.Printf("error al generar id de subida: %v", err)
+		http.Error(w, "no se pudo crear la subida", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now().UTC()
+	upload := tusUpload{
+		ID:        id,
+		Length:    length,
+		Metadata:  metadata,
+		UserID:    claims.UserID,
+		Target:    target,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.tus.expiration),
+	}
+	if err := s.tus.create(upload); err != nil {
+		log.Printf("error al crear subida: %v", err)
+		http.Error(w, "no se pudo crear la subida", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", tusBasePath+id)
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *server) tusPatch(w http.ResponseWriter, r *http.Request, upload *tusUpload) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type debe ser application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Upload-Offset requerido", http.StatusBadRequest)
+		return
+	}
+	if offset != upload.Offset {
+		http.Error(w, errTusOffsetMismatch.Error(), http.StatusConflict)
+		return
+	}
+
+	if upload.Offset < upload.Length {
+		if err := s.tus.appendChunk(upload, r.Body); err != nil {
+			// Lo recibido hasta el corte queda guardado; el cliente reanuda con HEAD.
+			log.Printf("subida %s interrumpida en %d: %v", upload.ID, upload.Offset, err)
+			http.Error(w, "no se pudo guardar el fragmento", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+	if upload.Offset < upload.Length {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	s.tusComplete(w, r, upload)
+}
+
+// tusComplete encola la subida terminada. Si falla por algo transitorio se
+// conserva, y un PATCH vacío en el offset final reintenta.
+func (s *server) tusComplete(w http.ResponseWriter, r *http.Request, upload *tusUpload) {
+	claims, _ := getUserClaims(r.Context())
+
+	checksum, err := upload.checksum()
+	if err != nil {
+		log.Printf("subida tus %s: %v", upload.ID, err)
+		s.tus.remove(upload.ID)
+		http.Error(w, "no se pudo procesar la subida", http.StatusInternalServerError)
+		return
+	}
+
+	res, err := s.ingestUpload(r.Context(), ingestRequest{
+		SourcePath:     s.tus.dataPath(upload.ID),
+		SourceChecksum: checksum,
+		OriginalName:   upload.Metadata["filename"],
+		TargetName:     upload.Target,
+		Title:          upload.Metadata["title"],
+		Claims:         claims,
+	})
+	if err != nil {
+		if !isTransientIngestError(err) {
+			s.tus.remove(upload.ID)
+		}
+		s.writeIngestError(w, upload.Target, err)
+		return
+	}
+
+	// ingestUpload ya movió los datos a la carpeta de trabajos.
+	s.tus.remove(upload.ID)
+	w.Header().Set("Wasabi-Sound-Name", res.Name)
+	if res.DuplicateOf != "" {
+		w.Header().Set("Wasabi-Duplicate-Of", res.DuplicateOf)
+	} else {
+		w.Header().Set("Wasabi-Job-Id", res.JobID)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func isTransientIngestError(err error) bool {
+	var rejected *policyError
+	switch {
+	case errors.As(err, &rejected),
+		errors.Is(err, errUnsupportedFormat),
+		errors.Is(err, errUnreadableAudio),
+		errors.Is(err, errSoundExists),
+		errors.As(err, new(*duplicateError)):
+		return false
+	}
+	return true
+}
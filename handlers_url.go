@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+type urlImportRequest struct {
+	URL   string `json:"url"`
+	Name  string `json:"name"`
+	Title string `json:"title"`
+}
+
+// urlImportHandler descarga un archivo remoto y lo pasa por la misma
+// validación y cola de conversión que /upload.
+func (s *server) urlImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "solo se permite POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, ok := getUserClaims(r.Context())
+	if !ok {
+		http.Error(w, "no se pudo obtener usuario", http.StatusInternalServerError)
+		return
+	}
+
+	var payload urlImportRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&payload); err != nil {
+		http.Error(w, "cuerpo JSON inválido", http.StatusBadRequest)
+		return
+	}
+
+	u, err := parseImportURL(payload.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fileName := strings.TrimSpace(payload.Name)
+	if fileName == "" {
+		fileName = path.Base(u.Path)
+	}
+	safeName, err := sanitizeName(fileName)
+	if err != nil {
+		http.Error(w, "nombre requerido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	finalName := ensureMP3Name(safeName)
+	taken, err := s.targetTaken(r.Context(), finalName)
+	if err != nil {
+		log.Printf("error al verificar destino: %v", err)
+		http.Error(w, "no se pudo importar el archivo", http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		http.Error(w, "ya existe un archivo con ese nombre", http.StatusConflict)
+		return
+	}
+
+	tmp, err := os.CreateTemp(s.jobsDir, ".incoming-*")
+	if err != nil {
+		log.Printf("error al preparar descarga: %v", err)
+		http.Error(w, "no se pudo importar el archivo", http.StatusInternalServerError)
+		return
+	}
+	spoolPath := tmp.Name()
+	defer os.Remove(spoolPath)
+
+	hash := sha256.New()
+	originalName, err := s.urlFetcher.download(r.Context(), u, io.MultiWriter(tmp, hash), s.uploadPolicy)
+	if closeErr := tmp.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Printf("importación desde %s rechazada: user_id=%s: %v", u.Redacted(), claims.UserID, err)
+		writeURLImportError(w, err)
+		return
+	}
+
+	res, err := s.ingestUpload(r.Context(), ingestRequest{
+		SourcePath:     spoolPath,
+		SourceChecksum: hex.EncodeToString(hash.Sum(nil)),
+		OriginalName:   originalName,
+		TargetName:     finalName,
+		Title:          payload.Title,
+		Claims:         claims,
+	})
+	if err != nil {
+		s.writeIngestError(w, finalName, err)
+		return
+	}
+
+	log.Printf("importación desde URL: user_id=%s sound=%s url=%s", claims.UserID, res.Name, u.Redacted())
+	writeIngestResult(w, res)
+}
+
+func writeURLImportError(w http.ResponseWriter, err error) {
+	var rejected *policyError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &rejected):
+		writePolicyRejection(w, rejected)
+	case errors.Is(err, errURLBlocked):
+		http.Error(w, errURLBlocked.Error(), http.StatusForbidden)
+	case errors.Is(err, errURLInvalid):
+		http.Error(w, errURLInvalid.Error(), http.StatusBadRequest)
+	case errors.Is(err, errURLContentType):
+		http.Error(w, errURLContentType.Error(), http.StatusUnsupportedMediaType)
+	case errors.Is(err, errURLTooManyRedirect):
+		http.Error(w, errURLTooManyRedirect.Error(), http.StatusBadGateway)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		http.Error(w, "la descarga tardó demasiado", http.StatusGatewayTimeout)
+	default:
+		http.Error(w, errURLDownloadFailed.Error(), http.StatusBadGateway)
+	}
+}
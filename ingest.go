@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SourceChecksum es el SHA-256 de los bytes subidos, calculado mientras se
+// recibían; si viene vacío se calcula leyendo el archivo.
+type ingestRequest struct {
+	SourcePath     string
+	SourceChecksum string
+	OriginalName   string
+	TargetName     string
+	Title          string
+	Claims         *jwtClaims
+}
+
+// ingestResult trae DuplicateOf en lugar de JobID cuando el contenido ya
+// existía y DUPLICATE_POLICY=link: Name ya está listo, copiado del sonido
+// existente sin pasar por la cola.
+type ingestResult struct {
+	Name        string `json:"name"`
+	JobID       string `json:"jobId,omitempty"`
+	DuplicateOf string `json:"duplicateOf,omitempty"`
+}
+
+// ingestUpload valida un archivo ya guardado en disco, reserva su nombre en
+// el catálogo y encola la conversión. El archivo pasa a ser del trabajo.
+func (s *server) ingestUpload(ctx context.Context, req ingestRequest) (ingestResult, error) {
+	stat, err := os.Stat(req.SourcePath)
+	if err != nil {
+		return ingestResult{}, fmt.Errorf("no se pudo leer la subida: %w", err)
+	}
+
+	checksum := req.SourceChecksum
+	if checksum == "" {
+		if checksum, err = fileChecksum(req.SourcePath); err != nil {
+			return ingestResult{}, fmt.Errorf("no se pudo leer la subida: %w", err)
+		}
+	}
+
+	existing, found, err := s.findDuplicate(ctx, checksum)
+	if err != nil {
+		return ingestResult{}, fmt.Errorf("no se pudo buscar duplicados: %w", err)
+	}
+	if found && s.uploadPolicy.Duplicates != duplicatesLink {
+		return ingestResult{}, &duplicateError{Existing: existing}
+	}
+	if found {
+		rec, err := s.findSound(ctx, existing)
+		if err != nil && !errors.Is(err, errSoundNotFound) {
+			return ingestResult{}, err
+		}
+		// Si el original todavía se está convirtiendo no hay nada que copiar:
+		// la subida sigue el camino normal.
+		if err == nil && rec.Status != soundProcessing {
+			if err := s.linkDuplicate(ctx, req, checksum, rec); err != nil {
+				return ingestResult{}, err
+			}
+			return ingestResult{Name: req.TargetName, DuplicateOf: existing}, nil
+		}
+	}
+
+	// El formato se decide por el contenido; el nombre subido solo se guarda.
+	format, err := sniffFile(req.SourcePath)
+	if err != nil {
+		return ingestResult{}, err
+	}
+	if !s.allowsFormat(format) {
+		return ingestResult{}, fmt.Errorf("%w: %s", errUnsupportedFormat, format.Name)
+	}
+
+	info, err := s.ffmpeg.probeAudio(ctx, req.SourcePath)
+	if err != nil {
+		return ingestResult{}, err
+	}
+
+	if err := s.uploadPolicy.check(stat.Size(), info); err != nil {
+		return ingestResult{}, err
+	}
+
+	exists, err := s.objectExists(ctx, req.TargetName)
+	if err != nil {
+		return ingestResult{}, fmt.Errorf("no se pudo verificar destino: %w", err)
+	}
+	if exists {
+		return ingestResult{}, errSoundExists
+	}
+
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		title = titleFromName(req.TargetName)
+	}
+
+	now := time.Now().UTC()
+	err = s.insertSound(ctx, soundRecord{
+		Name:           req.TargetName,
+		Title:          title,
+		Status:         soundProcessing,
+		OriginalName:   req.OriginalName,
+		SourceChecksum: checksum,
+		OriginalFormat: strings.TrimPrefix(strings.ToLower(filepath.Ext(req.OriginalName)), "."),
+		DetectedFormat: format.Name,
+		UploaderID:     req.Claims.UserID,
+		UploaderName:   req.Claims.Username,
+		Audio:          info,
+		UploadedAt:     now,
+		ModifiedAt:     now,
+	})
+	if err != nil {
+		return ingestResult{}, err
+	}
+
+	jobID := newJobID()
+	inputPath := filepath.Join(s.jobsDir, jobID+format.Ext)
+	if err := os.Rename(req.SourcePath, inputPath); err != nil {
+		s.abandonReservation(ctx, req.TargetName)
+		return ingestResult{}, fmt.Errorf("no se pudo preparar el trabajo: %w", err)
+	}
+
+	err = s.enqueueJob(ctx, transcodeJob{
+		ID:        jobID,
+		SoundName: req.TargetName,
+		InputPath: inputPath,
+		SourceExt: format.Ext,
+		UserID:    req.Claims.UserID,
+	})
+	if err != nil {
+		os.Remove(inputPath)
+		s.abandonReservation(ctx, req.TargetName)
+		return ingestResult{}, err
+	}
+
+	return ingestResult{Name: req.TargetName, JobID: jobID}, nil
+}
+
+// targetTaken revisa si el nombre ya está en el almacenamiento o reservado en
+// el catálogo, para rechazar la subida antes de recibir o descargar datos.
+func (s *server) targetTaken(ctx context.Context, name string) (bool, error) {
+	exists, err := s.objectExists(ctx, name)
+	if err != nil || exists {
+		return exists, err
+	}
+
+	_, err = s.findSound(ctx, name)
+	if errors.Is(err, errSoundNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *server) abandonReservation(ctx context.Context, name string) {
+	if err := s.releaseSoundReservation(ctx, name); err != nil {
+		log.Printf("no se pudo liberar el nombre %s: %v", name, err)
+	}
+}
+
+// spoolUpload guarda la subida en la carpeta de trabajos para que luego
+// pueda moverse sin copiar, y devuelve el SHA-256 calculado al escribirla.
+func (s *server) spoolUpload(src io.Reader) (string, string, error) {
+	tmp, err := os.CreateTemp(s.jobsDir, ".incoming-*")
+	if err != nil {
+		return "", "", fmt.Errorf("no se pudo preparar el archivo temporal: %w", err)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), src); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", "", fmt.Errorf("no se pudo guardar el archivo temporal: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", "", fmt.Errorf("no se pudo cerrar el archivo temporal: %w", err)
+	}
+
+	return tmp.Name(), hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (s *server) writeIngestError(w http.ResponseWriter, name string, err error) {
+	var rejected *policyError
+	var duplicate *duplicateError
+	switch {
+	case errors.As(err, &rejected):
+		log.Printf("archivo rechazado por política %s: %v", name, err)
+		writePolicyRejection(w, rejected)
+	case errors.As(err, &duplicate):
+		log.Printf("archivo duplicado %s: ya existe como %s", name, duplicate.Existing)
+		writeJSON(w, http.StatusConflict, map[string]string{
+			"error":       "duplicate",
+			"message":     duplicate.Error(),
+			"duplicateOf": duplicate.Existing,
+		})
+	case errors.Is(err, errUnsupportedFormat):
+		log.Printf("archivo rechazado %s: %v", name, err)
+		http.Error(w, "formato no soportado, usa "+s.allowedFormatNames(), http.StatusUnsupportedMediaType)
+	case errors.Is(err, errUnreadableAudio):
+		log.Printf("archivo rechazado %s: %v", name, err)
+		http.Error(w, "el archivo no es un audio válido", http.StatusBadRequest)
+	case errors.Is(err, errSoundExists):
+		http.Error(w, "ya existe un archivo con ese nombre", http.StatusConflict)
+	case errors.Is(err, errQueueFull):
+		http.Error(w, "hay demasiadas conversiones pendientes, intenta más tarde", http.StatusServiceUnavailable)
+	default:
+		log.Printf("error al procesar subida %s: %v", name, err)
+		writeProcessingError(w, err, "no se pudo procesar el archivo")
+	}
+}
+
+// writeIngestResult responde 202 con el trabajo encolado, o 201 con el sonido
+// ya listo si se enlazó a un duplicado.
+func writeIngestResult(w http.ResponseWriter, res ingestResult) {
+	if res.DuplicateOf != "" {
+		writeJSON(w, http.StatusCreated, map[string]string{
+			"message":     "el archivo ya existía, se creó el sonido con una copia del existente",
+			"name":        res.Name,
+			"duplicateOf": res.DuplicateOf,
+		})
+		return
+	}
+	writeJSON(w, http.StatusAccepted, ingestAccepted(res))
+}
+
+func ingestAccepted(res ingestResult) map[string]string {
+	return map[string]string{
+		"message": "archivo en proceso",
+		"name":    res.Name,
+		"jobId":   res.JobID,
+		"status":  string(jobQueued),
+		"job":     "/jobs/" + res.JobID,
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type jobStatus string
+
+const (
+	jobQueued  jobStatus = "queued"
+	jobRunning jobStatus = "running"
+	jobDone    jobStatus = "done"
+	jobFailed  jobStatus = "failed"
+)
+
+var (
+	errJobNotFound = errors.New("trabajo no encontrado")
+	errQueueFull   = errors.New("la cola de conversión está llena")
+	errBusy        = errors.New("hay demasiadas conversiones en curso")
+)
+
+type transcodeJob struct {
+	ID         string            `bson:"_id" json:"id"`
+	Status     jobStatus         `bson:"status" json:"status"`
+	SoundName  string            `bson:"soundName" json:"soundName"`
+	InputPath  string            `bson:"inputPath" json:"-"`
+	SourceExt  string            `bson:"sourceExt" json:"-"`
+	UserID     string            `bson:"userId" json:"userId"`
+	Instance   string            `bson:"instance" json:"-"`
+	LeaseUntil time.Time         `bson:"leaseUntil" json:"-"`
+	Error      string            `bson:"error,omitempty" json:"error,omitempty"`
+	Violations []policyViolation `bson:"violations,omitempty" json:"violations,omitempty"`
+	CreatedAt  time.Time         `bson:"createdAt" json:"createdAt"`
+	UpdatedAt  time.Time         `bson:"updatedAt" json:"updatedAt"`
+	FinishedAt *time.Time        `bson:"finishedAt,omitempty" json:"finishedAt,omitempty"`
+}
+
+func newJobID() string {
+	return primitive.NewObjectID().Hex()
+}
+
+func (s *server) ensureJobIndexes(ctx context.Context) error {
+	_, err := s.jobsCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: 1}}},
+		{Keys: bson.D{{Key: "instance", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "finishedAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32((7 * 24 * time.Hour).Seconds()))},
+	})
+	return err
+}
+
+// enqueueJob persiste el trabajo antes de entregarlo a los workers, así un
+// reinicio puede retomarlo. Si la cola está llena el trabajo se descarta.
+func (s *server) enqueueJob(ctx context.Context, job transcodeJob) error {
+	now := time.Now().UTC()
+	job.Status = jobQueued
+	job.Instance = s.instanceID
+	job.LeaseUntil = now.Add(s.jobLease)
+	job.CreatedAt = now
+	job.UpdatedAt = now
+
+	if _, err := s.jobsCollection.InsertOne(ctx, job); err != nil {
+		return fmt.Errorf("no se pudo registrar el trabajo: %w", err)
+	}
+
+	select {
+	case s.jobQueue <- job.ID:
+		return nil
+	default:
+		if _, err := s.jobsCollection.DeleteOne(ctx, bson.M{"_id": job.ID}); err != nil {
+			log.Printf("error al descartar trabajo %s: %v", job.ID, err)
+		}
+		return errQueueFull
+	}
+}
+
+func (s *server) findJob(ctx context.Context, id string) (transcodeJob, error) {
+	var job transcodeJob
+	err := s.jobsCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return transcodeJob{}, errJobNotFound
+	}
+	return job, err
+}
+
+func (s *server) updateJob(ctx context.Context, id string, set bson.M) error {
+	set["updatedAt"] = time.Now().UTC()
+	_, err := s.jobsCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+	return err
+}
+
+// claimJob pasa el trabajo de en cola a en curso de forma atómica, así un
+// mismo ID encolado dos veces solo se procesa una vez.
+func (s *server) claimJob(ctx context.Context, id string) (transcodeJob, error) {
+	var job transcodeJob
+	err := s.jobsCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "status": jobQueued, "instance": s.instanceID},
+		bson.M{"$set": bson.M{"status": jobRunning, "updatedAt": time.Now().UTC()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return transcodeJob{}, errJobNotFound
+	}
+	return job, err
+}
+
+func (s *server) startJobWorkers(ctx context.Context) {
+	for i := 0; i < s.jobWorkers; i++ {
+		go s.runJobWorker(ctx)
+	}
+
+	go func() {
+		if err := s.resumeJobs(ctx); err != nil {
+			log.Printf("no se pudieron retomar trabajos pendientes: %v", err)
+		}
+		s.runJobLeases(ctx)
+	}()
+}
+
+// resumeJobs vuelve a encolar lo que esta instancia dejó pendiente antes de
+// un reinicio, más lo que abandonaron otras instancias. Los trabajos que
+// estaban en curso se reinician desde cero.
+func (s *server) resumeJobs(ctx context.Context) error {
+	_, err := s.jobsCollection.UpdateMany(ctx,
+		bson.M{"status": jobRunning, "instance": s.instanceID},
+		bson.M{"$set": bson.M{"status": jobQueued, "updatedAt": time.Now().UTC()}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.adoptExpiredJobs(ctx); err != nil {
+		return err
+	}
+
+	cursor, err := s.jobsCollection.Find(ctx,
+		bson.M{"status": jobQueued, "instance": s.instanceID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}),
+	)
+	if err != nil {
+		return err
+	}
+
+	var pending []transcodeJob
+	if err := cursor.All(ctx, &pending); err != nil {
+		return err
+	}
+	return s.requeueJobs(ctx, pending)
+}
+
+// adoptExpiredJobs toma los trabajos cuya instancia dejó de renovar la
+// concesión, por ejemplo porque se apagó para siempre. Quedan en cola a
+// nombre de esta instancia; cada documento lo adopta una sola.
+func (s *server) adoptExpiredJobs(ctx context.Context) ([]transcodeJob, error) {
+	now := time.Now().UTC()
+	filter := bson.M{
+		"status":     bson.M{"$in": []jobStatus{jobQueued, jobRunning}},
+		"instance":   bson.M{"$ne": s.instanceID},
+		"leaseUntil": bson.M{"$not": bson.M{"$gt": now}},
+	}
+	cursor, err := s.jobsCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var expired []transcodeJob
+	if err := cursor.All(ctx, &expired); err != nil {
+		return nil, err
+	}
+
+	var adopted []transcodeJob
+	for _, job := range expired {
+		filter["_id"] = job.ID
+		res, err := s.jobsCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+			"status":     jobQueued,
+			"instance":   s.instanceID,
+			"leaseUntil": now.Add(s.jobLease),
+			"updatedAt":  now,
+		}})
+		if err != nil {
+			return adopted, err
+		}
+		if res.ModifiedCount == 0 {
+			continue
+		}
+		log.Printf("adoptando trabajo %s de la instancia %q", job.ID, job.Instance)
+		job.Status = jobQueued
+		job.Instance = s.instanceID
+		adopted = append(adopted, job)
+	}
+	return adopted, nil
+}
+
+// requeueJobs entrega los trabajos a los workers. Si la entrada quedó en el
+// disco de otra instancia, el trabajo no se puede hacer aquí y se marca como
+// fallido para liberar el nombre.
+func (s *server) requeueJobs(ctx context.Context, jobs []transcodeJob) error {
+	for _, job := range jobs {
+		if _, err := os.Stat(job.InputPath); err != nil {
+			s.failJob(ctx, job, fmt.Errorf("el archivo de entrada no está disponible en esta instancia: %w", err))
+			continue
+		}
+
+		log.Printf("retomando trabajo %s (%s)", job.ID, job.SoundName)
+		select {
+		case s.jobQueue <- job.ID:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// runJobLeases renueva la concesión de los trabajos propios, en cola o en
+// curso, y adopta los que otras instancias dejaron vencer.
+func (s *server) runJobLeases(ctx context.Context) {
+	ticker := time.NewTicker(s.jobLease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now().UTC()
+		_, err := s.jobsCollection.UpdateMany(ctx,
+			bson.M{"status": bson.M{"$in": []jobStatus{jobQueued, jobRunning}}, "instance": s.instanceID},
+			bson.M{"$set": bson.M{"leaseUntil": now.Add(s.jobLease)}},
+		)
+		if err != nil {
+			log.Printf("no se pudieron renovar las concesiones de trabajos: %v", err)
+			continue
+		}
+
+		adopted, err := s.adoptExpiredJobs(ctx)
+		if err != nil {
+			log.Printf("no se pudieron adoptar trabajos vencidos: %v", err)
+		}
+		if err := s.requeueJobs(ctx, adopted); err != nil {
+			return
+		}
+	}
+}
+
+// acquireTranscode espera un lugar libre para ffmpeg; lo usan los trabajos.
+func (s *server) acquireTranscode(ctx context.Context) (func(), error) {
+	select {
+	case s.transcodeSlots <- struct{}{}:
+		return func() { <-s.transcodeSlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// tryAcquireTranscode es para conversiones dentro de una petición: si no hay
+// lugar responde errBusy en vez de esperar con la conexión abierta.
+func (s *server) tryAcquireTranscode() (func(), error) {
+	select {
+	case s.transcodeSlots <- struct{}{}:
+		return func() { <-s.transcodeSlots }, nil
+	default:
+		return nil, errBusy
+	}
+}
+
+func (s *server) runJobWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-s.jobQueue:
+			s.processJob(ctx, id)
+		}
+	}
+}
+
+func (s *server) processJob(ctx context.Context, id string) {
+	job, err := s.claimJob(ctx, id)
+	if errors.Is(err, errJobNotFound) {
+		// Ya lo tomó otro worker o dejó de estar en cola.
+		return
+	}
+	if err != nil {
+		log.Printf("no se pudo leer trabajo %s: %v", id, err)
+		return
+	}
+
+	release, err := s.acquireTranscode(ctx)
+	if err != nil {
+		return
+	}
+	jobCtx, cancel := context.WithTimeout(ctx, s.jobTimeout)
+	stored, err := s.runTranscodeJob(jobCtx, job)
+	cancel()
+	release()
+	if err != nil {
+		s.failJob(ctx, job, err)
+		return
+	}
+
+	if err := s.updateSoundAudio(ctx, job.SoundName, stored); err != nil {
+		// El nombre sigue reservado por este trabajo, así que el archivo recién
+		// guardado es suyo: se borra para no dejarlo huérfano.
+		if delErr := s.store.Delete(ctx, job.SoundName); delErr != nil && !errors.Is(delErr, errObjectNotFound) {
+			log.Printf("no se pudo borrar %s tras fallar el catálogo: %v", job.SoundName, delErr)
+		}
+		s.failJob(ctx, job, fmt.Errorf("no se pudo completar el catálogo: %w", err))
+		return
+	}
+
+	now := time.Now().UTC()
+	if err := s.updateJob(ctx, id, bson.M{"status": jobDone, "finishedAt": now}); err != nil {
+		log.Printf("no se pudo marcar trabajo %s como terminado: %v", id, err)
+	}
+	os.Remove(job.InputPath)
+	log.Printf("trabajo %s terminado: %s", id, job.SoundName)
+}
+
+func (s *server) runTranscodeJob(ctx context.Context, job transcodeJob) (storedAudio, error) {
+	if err := s.uploadPolicy.checkLoudness(ctx, s.ffmpeg, job.InputPath); err != nil {
+		return storedAudio{}, err
+	}
+	return s.convertAndSaveAsMP3(ctx, job.InputPath, job.SourceExt, job.SoundName)
+}
+
+func (s *server) failJob(ctx context.Context, job transcodeJob, cause error) {
+	log.Printf("trabajo %s falló (%s): %v", job.ID, job.SoundName, cause)
+
+	set := bson.M{
+		"status":     jobFailed,
+		"error":      cause.Error(),
+		"finishedAt": time.Now().UTC(),
+	}
+	var rejected *policyError
+	if errors.As(cause, &rejected) {
+		set["violations"] = rejected.violations
+	}
+	if err := s.updateJob(ctx, job.ID, set); err != nil {
+		log.Printf("no se pudo marcar trabajo %s como fallido: %v", job.ID, err)
+	}
+
+	s.abandonReservation(ctx, job.SoundName)
+	os.Remove(job.InputPath)
+}
+
+// staleFileAge es la antigüedad a partir de la cual un temporal se considera
+// abandonado. Varias instancias pueden compartir DATA_DIR, así que al iniciar
+// no se borra lo que otra podría estar escribiendo todavía.
+const staleFileAge = 24 * time.Hour
+
+// removeStaleFiles borra lo que coincide con pattern en dir y no se modificó
+// en staleFileAge: subidas a medio guardar o temporales de ffmpeg que quedaron
+// cuando el servidor se detuvo.
+func removeStaleFiles(dir, pattern string) {
+	matches, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		return
+	}
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || time.Since(info.ModTime()) < staleFileAge {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			log.Printf("no se pudo borrar temporal abandonado %s: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	libraryArchiveVersion = 1
+	archiveManifestFile   = "manifest.json"
+	archiveSoundsFile     = "sounds.json"
+	archiveIntrosFile     = "intros.json"
+	archiveSoundsDir      = "sounds/"
+
+	// maxArchiveMetadata acota lo que se lee en memoria de los JSON del respaldo.
+	maxArchiveMetadata = 256 << 20
+)
+
+const (
+	importMerge     = "merge"
+	importOverwrite = "overwrite"
+)
+
+var errInvalidArchive = errors.New("archivo de respaldo inválido")
+
+// libraryManifest va primero en el respaldo; el resto de los JSON usan el
+// mismo formato (Extended JSON de Mongo) para conservar tipos como fechas.
+type libraryManifest struct {
+	Version    int       `bson:"version"`
+	ExportedAt time.Time `bson:"exportedAt"`
+	Sounds     int       `bson:"sounds"`
+	Intros     int       `bson:"intros"`
+}
+
+type archiveSounds struct {
+	Sounds []soundRecord `bson:"sounds"`
+}
+
+type archiveIntros struct {
+	Intros []bson.M `bson:"intros"`
+}
+
+type importReport struct {
+	Strategy        string   `json:"strategy"`
+	SoundsRestored  int      `json:"soundsRestored"`
+	SoundsSkipped   int      `json:"soundsSkipped"`
+	CatalogRestored int      `json:"catalogRestored"`
+	IntrosRestored  int      `json:"introsRestored"`
+	IntrosSkipped   int      `json:"introsSkipped"`
+	Errors          []string `json:"errors,omitempty"`
+}
+
+type archiveWriter interface {
+	add(name string, size int64, modified time.Time, src io.Reader) error
+	Close() error
+}
+
+type tarGzWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarGzWriter(w io.Writer) *tarGzWriter {
+	gz := gzip.NewWriter(w)
+	return &tarGzWriter{gz: gz, tw: tar.NewWriter(gz)}
+}
+
+func (a *tarGzWriter) add(name string, size int64, modified time.Time, src io.Reader) error {
+	err := a.tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0o644,
+		Size:     size,
+		ModTime:  modified,
+		Typeflag: tar.TypeReg,
+	})
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(a.tw, src)
+	if err == nil && n != size {
+		err = fmt.Errorf("%s cambió durante la exportación", name)
+	}
+	return err
+}
+
+func (a *tarGzWriter) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+type zipArchiveWriter struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchiveWriter) add(name string, _ int64, modified time.Time, src io.Reader) error {
+	// El audio ya viene comprimido; solo vale la pena comprimir los JSON.
+	method := zip.Store
+	if path.Ext(name) == ".json" {
+		method = zip.Deflate
+	}
+	w, err := a.zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
+
+func (a *zipArchiveWriter) Close() error {
+	return a.zw.Close()
+}
+
+func addArchiveJSON(aw archiveWriter, name string, v any, modified time.Time) error {
+	raw, err := bson.MarshalExtJSON(v, false, false)
+	if err != nil {
+		return fmt.Errorf("no se pudo serializar %s: %w", name, err)
+	}
+	return aw.add(name, int64(len(raw)), modified, bytes.NewReader(raw))
+}
+
+// libraryMetadata lee el catálogo completo (incluidas las formas de onda) y
+// las intros, sin los _id de Mongo para que se puedan importar en otra base.
+func (s *server) libraryMetadata(ctx context.Context) ([]soundRecord, []bson.M, error) {
+	cursor, err := s.soundsCollection.Find(ctx, readySounds(bson.M{}), options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, nil, fmt.Errorf("no se pudo leer el catálogo: %w", err)
+	}
+	sounds := []soundRecord{}
+	if err := cursor.All(ctx, &sounds); err != nil {
+		return nil, nil, fmt.Errorf("no se pudo leer el catálogo: %w", err)
+	}
+
+	cursor, err = s.introsCollection.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"_id": 0}))
+	if err != nil {
+		return nil, nil, fmt.Errorf("no se pudieron leer las intros: %w", err)
+	}
+	intros := []bson.M{}
+	if err := cursor.All(ctx, &intros); err != nil {
+		return nil, nil, fmt.Errorf("no se pudieron leer las intros: %w", err)
+	}
+
+	return sounds, intros, nil
+}
+
+// exportLibrary escribe el manifiesto, el catálogo, las intros y cada sonido
+// del almacenamiento. Si falla a mitad, el archivo queda sin cerrar y el
+// cliente recibe un respaldo truncado que no pasa la importación.
+func (s *server) exportLibrary(ctx context.Context, aw archiveWriter, sounds []soundRecord, intros []bson.M) error {
+	objects, err := s.store.List(ctx)
+	if err != nil {
+		return fmt.Errorf("no se pudo listar almacenamiento: %w", err)
+	}
+
+	now := time.Now().UTC()
+	manifest := libraryManifest{Version: libraryArchiveVersion, ExportedAt: now, Sounds: len(objects), Intros: len(intros)}
+	if err := addArchiveJSON(aw, archiveManifestFile, manifest, now); err != nil {
+		return err
+	}
+	if err := addArchiveJSON(aw, archiveSoundsFile, archiveSounds{Sounds: sounds}, now); err != nil {
+		return err
+	}
+	if err := addArchiveJSON(aw, archiveIntrosFile, archiveIntros{Intros: intros}, now); err != nil {
+		return err
+	}
+
+	for _, obj := range objects {
+		content, info, err := s.store.Get(ctx, obj.Name)
+		if errors.Is(err, errObjectNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("no se pudo leer %s: %w", obj.Name, err)
+		}
+		err = aw.add(archiveSoundsDir+obj.Name, info.Size, info.Modified, content)
+		content.Close()
+		if err != nil {
+			return fmt.Errorf("no se pudo exportar %s: %w", obj.Name, err)
+		}
+	}
+
+	return aw.Close()
+}
+
+// readArchive recorre los archivos regulares de un zip o tar.gz, según sus
+// primeros bytes.
+func readArchive(archivePath string, fn func(name string, r io.Reader) error) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(f, head); err != nil {
+		return errInvalidArchive
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	switch {
+	case bytes.Equal(head, zipMagic):
+		stat, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		zr, err := zip.NewReader(f, stat.Size())
+		if err != nil && !errors.Is(err, zip.ErrInsecurePath) {
+			return errInvalidArchive
+		}
+		for _, entry := range zr.File {
+			if entry.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := entry.Open()
+			if err != nil {
+				return errInvalidArchive
+			}
+			err = fn(entry.Name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case head[0] == 0x1f && head[1] == 0x8b:
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return errInvalidArchive
+		}
+		defer gz.Close()
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("%w: %v", errInvalidArchive, err)
+			}
+			if hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+			if err := fn(hdr.Name, tr); err != nil {
+				return err
+			}
+		}
+	}
+	return errInvalidArchive
+}
+
+func readArchiveJSON(r io.Reader, v any) error {
+	raw, err := io.ReadAll(io.LimitReader(r, maxArchiveMetadata))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidArchive, err)
+	}
+	if err := bson.UnmarshalExtJSON(raw, false, v); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidArchive, err)
+	}
+	return nil
+}
+
+// importLibrary restaura un respaldo de exportLibrary. Con merge se conserva
+// todo lo que ya existe; con overwrite lo del respaldo reemplaza sonidos,
+// metadatos e intros con el mismo nombre o usuario. Nunca se borra nada que
+// no esté en el respaldo.
+func (s *server) importLibrary(ctx context.Context, archivePath, strategy string) (importReport, error) {
+	report := importReport{Strategy: strategy}
+
+	var manifest *libraryManifest
+	var sounds archiveSounds
+	var intros archiveIntros
+	restored := make(map[string]importedSound)
+
+	err := readArchive(archivePath, func(name string, r io.Reader) error {
+		switch {
+		case name == archiveManifestFile:
+			manifest = &libraryManifest{}
+			if err := readArchiveJSON(r, manifest); err != nil {
+				return err
+			}
+			if manifest.Version != libraryArchiveVersion {
+				return fmt.Errorf("%w: versión %d no soportada", errInvalidArchive, manifest.Version)
+			}
+		case manifest == nil:
+			return fmt.Errorf("%w: falta %s al inicio", errInvalidArchive, archiveManifestFile)
+		case name == archiveSoundsFile:
+			return readArchiveJSON(r, &sounds)
+		case name == archiveIntrosFile:
+			return readArchiveJSON(r, &intros)
+		case strings.HasPrefix(name, archiveSoundsDir):
+			soundName := strings.TrimPrefix(name, archiveSoundsDir)
+			if safe, err := sanitizeName(soundName); err != nil || safe != soundName {
+				report.Errors = append(report.Errors, "entrada ignorada: "+name)
+				return nil
+			}
+
+			reserved, skip, err := s.reserveImportedSound(ctx, soundName, strategy)
+			if err != nil {
+				return fmt.Errorf("no se pudo verificar %s: %w", soundName, err)
+			}
+			if skip != "" {
+				report.SoundsSkipped++
+				if strategy == importOverwrite {
+					report.Errors = append(report.Errors, fmt.Sprintf("%s omitido: %s", soundName, skip))
+				}
+				return nil
+			}
+
+			content := &contentHash{hash: sha256.New()}
+			if err := s.store.Put(ctx, soundName, io.TeeReader(r, content)); err != nil {
+				if reserved {
+					s.abandonReservation(ctx, soundName)
+				}
+				return fmt.Errorf("no se pudo guardar %s: %w", soundName, err)
+			}
+			restored[soundName] = importedSound{Checksum: hex.EncodeToString(content.hash.Sum(nil)), Size: content.size}
+			report.SoundsRestored++
+		}
+		return nil
+	})
+	if err != nil {
+		// Lo que ya se escribió queda importado: sus reservas se cierran para
+		// que no sigan ocultas y bloqueando el nombre.
+		for _, msg := range s.finalizeImportedSounds(context.WithoutCancel(ctx), restored) {
+			log.Printf("importación interrumpida: %s", msg)
+		}
+		return report, err
+	}
+	if manifest == nil {
+		return report, fmt.Errorf("%w: falta %s", errInvalidArchive, archiveManifestFile)
+	}
+
+	// Solo se importan metadatos de sonidos restaurados desde este respaldo, y
+	// el checksum y el tamaño salen del archivo que se guardó, no del JSON.
+	for _, rec := range sounds.Sounds {
+		stored, ok := restored[rec.Name]
+		if !ok || rec.Status == soundProcessing {
+			continue
+		}
+		rec.Checksum = stored.Checksum
+		rec.Size = stored.Size
+		if err := s.restoreSoundRecord(ctx, rec); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("metadatos de %s: %v", rec.Name, err))
+			continue
+		}
+		delete(restored, rec.Name)
+		report.CatalogRestored++
+	}
+
+	report.Errors = append(report.Errors, s.finalizeImportedSounds(ctx, restored)...)
+
+	for _, doc := range intros.Intros {
+		userID, _ := doc["id"].(string)
+		if userID == "" {
+			report.Errors = append(report.Errors, "intro sin id ignorada")
+			continue
+		}
+		restoredIntro, err := s.restoreIntro(ctx, userID, doc, strategy)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("intro de %s: %v", userID, err))
+			continue
+		}
+		if restoredIntro {
+			report.IntrosRestored++
+		} else {
+			report.IntrosSkipped++
+		}
+	}
+
+	// Agrega al catálogo lo que no se pudo registrar arriba.
+	if err := s.syncSoundCatalog(ctx); err != nil {
+		log.Printf("no se pudo sincronizar el catálogo después de importar: %v", err)
+		report.Errors = append(report.Errors, "no se pudo sincronizar el catálogo")
+	}
+
+	return report, nil
+}
+
+// finalizeImportedSounds cierra los archivos restaurados sin metadatos:
+// reemplazaron el contenido de un registro que ya existía (o de la reserva),
+// así que se actualiza su checksum y se descarta lo que dependía del audio
+// anterior. Devuelve los errores para el informe.
+func (s *server) finalizeImportedSounds(ctx context.Context, restored map[string]importedSound) []string {
+	var errs []string
+	for name, stored := range restored {
+		if err := s.resetSoundContent(ctx, name, stored); err != nil {
+			errs = append(errs, fmt.Sprintf("catálogo de %s: %v", name, err))
+		}
+	}
+	return errs
+}
+
+// importedSound es lo que realmente se guardó de un archivo del respaldo.
+type importedSound struct {
+	Checksum string
+	Size     int64
+}
+
+// contentHash calcula el checksum y el tamaño de lo que se escribe en él.
+type contentHash struct {
+	hash hash.Hash
+	size int64
+}
+
+func (c *contentHash) Write(p []byte) (int, error) {
+	c.size += int64(len(p))
+	return c.hash.Write(p)
+}
+
+// reserveImportedSound decide si se puede escribir el archivo del respaldo.
+// Un nombre nuevo se reserva en el catálogo antes de escribirlo, igual que
+// una subida. Con merge se omite lo que ya existe; con overwrite solo lo que
+// está reservado por una subida en curso. skip explica por qué se omite.
+func (s *server) reserveImportedSound(ctx context.Context, name, strategy string) (reserved bool, skip string, err error) {
+	if strategy == importMerge {
+		exists, err := s.objectExists(ctx, name)
+		if err != nil || exists {
+			return false, "ya existe", err
+		}
+	}
+
+	now := time.Now().UTC()
+	err = s.insertSound(ctx, soundRecord{
+		Name:           name,
+		Title:          titleFromName(name),
+		Status:         soundProcessing,
+		OriginalName:   name,
+		OriginalFormat: strings.TrimPrefix(strings.ToLower(path.Ext(name)), "."),
+		UploadedAt:     now,
+		ModifiedAt:     now,
+	})
+	if err == nil {
+		return true, "", nil
+	}
+	if !errors.Is(err, errSoundExists) {
+		return false, "", err
+	}
+	if strategy == importMerge {
+		return false, "ya existe", nil
+	}
+
+	rec, err := s.findSound(ctx, name)
+	if err != nil {
+		return false, "", err
+	}
+	if rec.Status == soundProcessing {
+		return false, "hay una subida en curso con ese nombre", nil
+	}
+	return false, "", nil
+}
+
+// resetSoundContent apunta el registro a un contenido nuevo del que no hay
+// metadatos: la forma de onda y la sonoridad se regeneran o se miden de nuevo.
+func (s *server) resetSoundContent(ctx context.Context, name string, stored importedSound) error {
+	var previous soundRecord
+	err := s.soundsCollection.FindOneAndUpdate(ctx, bson.M{"name": name}, bson.M{
+		"$set": bson.M{"checksum": stored.Checksum, "size": stored.Size, "modifiedAt": time.Now().UTC()},
+		"$unset": bson.M{
+			"status": "", "peaks": "", "loudness": "",
+			"duration": "", "sampleRate": "", "channels": "", "bitrate": "", "codec": "",
+		},
+	}).Decode(&previous)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// syncSoundCatalog lo registra.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if previous.Checksum != stored.Checksum {
+		s.renditions.invalidate(previous.Checksum)
+	}
+	return nil
+}
+
+// restoreSoundRecord reemplaza el registro del sonido por el del respaldo y
+// descarta las conversiones del contenido anterior.
+func (s *server) restoreSoundRecord(ctx context.Context, rec soundRecord) error {
+	var previous soundRecord
+	err := s.soundsCollection.FindOneAndReplace(ctx, bson.M{"name": rec.Name}, rec, options.FindOneAndReplace().SetUpsert(true)).Decode(&previous)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	if previous.Checksum != "" && previous.Checksum != rec.Checksum {
+		s.renditions.invalidate(previous.Checksum)
+	}
+	return nil
+}
+
+func (s *server) restoreIntro(ctx context.Context, userID string, doc bson.M, strategy string) (bool, error) {
+	delete(doc, "_id")
+	if strategy == importOverwrite {
+		_, err := s.introsCollection.ReplaceOne(ctx, bson.M{"id": userID}, doc, options.Replace().SetUpsert(true))
+		return err == nil, err
+	}
+
+	res, err := s.introsCollection.UpdateOne(ctx, bson.M{"id": userID}, bson.M{"$setOnInsert": doc}, options.Update().SetUpsert(true))
+	if err != nil {
+		return false, err
+	}
+	return res.UpsertedCount > 0, nil
+}
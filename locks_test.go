@@ -0,0 +1,33 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestKeyLocksPrunesUnusedKeys(t *testing.T) {
+	k := newKeyLocks()
+
+	var wg sync.WaitGroup
+	counter := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := k.lock("misma")
+			counter++
+			unlock()
+		}()
+	}
+	wg.Wait()
+	if counter != 50 {
+		t.Errorf("counter = %d, el candado no serializó", counter)
+	}
+
+	for _, id := range []string{"a", "b", "c"} {
+		k.lock(id)()
+	}
+	if len(k.locks) != 0 {
+		t.Errorf("quedaron %d candados sin uso", len(k.locks))
+	}
+}
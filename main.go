@@ -1,6 +1,9 @@
 package main
 
-import "log"
+import (
+	"context"
+	"log"
+)
 
 func main() {
 	if err := loadDotEnv(".env"); err != nil {
@@ -17,8 +20,8 @@ func main() {
 		log.Fatalf("no se pudo inicializar el servidor: %v", err)
 	}
 
-	if err := server.ensureUploadDir(); err != nil {
-		log.Fatalf("no se pudo crear la carpeta de subida: %v", err)
+	if err := server.syncSoundCatalog(context.Background()); err != nil {
+		log.Printf("no se pudo sincronizar el catálogo de sonidos: %v", err)
 	}
 
 	server.listen(cfg.Addr)
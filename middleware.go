@@ -1,12 +1,38 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"slices"
 )
 
+const scopeContextKey contextKey = "scope"
+
+// authRequired acepta la cookie de sesión o un token de API en
+// Authorization: Bearer.
 func (s *server) authRequired(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := bearerToken(r); ok {
+			claims, err := s.authenticateAPIToken(r.Context(), token)
+			if errors.Is(err, errTokenInvalid) {
+				http.Error(w, errTokenInvalid.Error(), http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				log.Printf("error al validar token de API: %v", err)
+				http.Error(w, "no se pudo validar el token", http.StatusInternalServerError)
+				return
+			}
+			if s.membership.rejects(claims.UserID) {
+				http.Error(w, "debes ser miembro del servidor de Discord para usar Wasabi", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(contextWithUser(r.Context(), claims)))
+			return
+		}
+
 		cookie, err := r.Cookie("auth_token")
 		if err != nil {
 			http.Error(w, "no autenticado", http.StatusUnauthorized)
@@ -19,7 +45,19 @@ func (s *server) authRequired(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		if s.auth.requiredGuildID != "" && claims.GuildID != s.auth.requiredGuildID {
+		if err := s.checkSession(r.Context(), claims); err != nil {
+			if errors.Is(err, errSessionInvalid) {
+				http.Error(w, errSessionInvalid.Error(), http.StatusUnauthorized)
+				return
+			}
+			log.Printf("error al validar sesión: %v", err)
+			http.Error(w, "no se pudo validar la sesión", http.StatusInternalServerError)
+			return
+		}
+
+		// GuildID siempre coincide porque lo pone generateJWT; lo que sirve es
+		// la última verificación de membresía.
+		if claims.GuildID != s.auth.requiredGuildID || s.membership.rejects(claims.UserID) {
 			http.Error(w, "debes ser miembro del servidor de Discord para usar Wasabi", http.StatusForbidden)
 			return
 		}
@@ -29,6 +67,82 @@ func (s *server) authRequired(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+func (s *server) requireRole(role wasabiRole, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := getUserClaims(r.Context())
+		if !ok {
+			http.Error(w, "no se pudo obtener usuario", http.StatusInternalServerError)
+			return
+		}
+
+		// Un token de API solo entra a endpoints que declaran qué permiso piden.
+		if claims.TokenID != "" && r.Context().Value(scopeContextKey) == nil {
+			http.Error(w, "este endpoint no acepta tokens de API", http.StatusForbidden)
+			return
+		}
+
+		if !s.auth.roleOf(claims).atLeast(role) {
+			http.Error(w, "tu rol no permite esta acción", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// requireRoleForWrites solo exige el rol en métodos que modifican datos.
+func (s *server) requireRoleForWrites(role wasabiRole, next http.HandlerFunc) http.HandlerFunc {
+	guarded := s.requireRole(role, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		guarded.ServeHTTP(w, r)
+	}
+}
+
+// requireScope exige el permiso a los tokens de API; las sesiones del
+// navegador tienen todos los permisos de su rol.
+func (s *server) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := getUserClaims(r.Context())
+		if ok && claims.TokenID != "" {
+			if !slices.Contains(claims.Scopes, scope) {
+				http.Error(w, "el token no tiene el permiso "+scope, http.StatusForbidden)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), scopeContextKey, scope))
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// requireScopeForWrites pide readScope en GET y HEAD, y writeScope en el resto.
+func (s *server) requireScopeForWrites(readScope, writeScope string, next http.HandlerFunc) http.HandlerFunc {
+	reads := s.requireScope(readScope, next)
+	writes := s.requireScope(writeScope, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			reads.ServeHTTP(w, r)
+			return
+		}
+		writes.ServeHTTP(w, r)
+	}
+}
+
+// requireSession rechaza los tokens de API, para acciones que solo se hacen
+// desde el navegador.
+func requireSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if claims, ok := getUserClaims(r.Context()); ok && claims.TokenID != "" {
+			http.Error(w, "este endpoint no acepta tokens de API", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
 func logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("%s %s", r.Method, r.URL.Path)
@@ -51,13 +165,15 @@ func corsMiddleware(allowedOrigins []string, next http.Handler) http.Handler {
 			if _, ok := allowed[origin]; ok {
 				w.Header().Set("Access-Control-Allow-Origin", origin)
 				w.Header().Set("Access-Control-Allow-Credentials", "true")
-				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+				w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+tusRequestHeaders)
+				w.Header().Set("Access-Control-Expose-Headers", tusResponseHeaders)
 				w.Header().Add("Vary", "Origin")
 			}
 		}
 
-		if r.Method == http.MethodOptions {
+		// Solo se responde aquí el preflight; un OPTIONS normal es parte de tus.
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errUnknownRendition = errors.New("formato de descarga no soportado, usa mp3, opus, ogg o wav")
+
+// renditionFormat es un formato en el que se puede descargar un sonido. El
+// mp3 guardado se sirve tal cual; los demás se generan con ffmpeg.
+type renditionFormat struct {
+	Name        string
+	Ext         string
+	ContentType string
+	Args        []string
+}
+
+var (
+	renditionMP3  = renditionFormat{Name: "mp3", Ext: ".mp3", ContentType: "audio/mpeg"}
+	renditionOpus = renditionFormat{Name: "opus", Ext: ".opus", ContentType: "audio/ogg; codecs=opus", Args: []string{"-codec:a", "libopus", "-b:a", "96k", "-f", "ogg"}}
+	renditionOgg  = renditionFormat{Name: "ogg", Ext: ".ogg", ContentType: "audio/ogg", Args: []string{"-codec:a", "libvorbis", "-qscale:a", "5", "-f", "ogg"}}
+	renditionWAV  = renditionFormat{Name: "wav", Ext: ".wav", ContentType: "audio/wav", Args: []string{"-codec:a", "pcm_s16le", "-f", "wav"}}
+)
+
+var renditionsByName = map[string]renditionFormat{
+	"mp3":  renditionMP3,
+	"opus": renditionOpus,
+	"ogg":  renditionOgg,
+	"wav":  renditionWAV,
+}
+
+// negotiateRendition elige el formato pedido con ?format= o, si no viene, el
+// de mayor calidad (q) en Accept. Sin coincidencias se sirve el mp3.
+func negotiateRendition(r *http.Request) (renditionFormat, error) {
+	if raw := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format"))); raw != "" {
+		format, ok := renditionsByName[raw]
+		if !ok {
+			return renditionFormat{}, errUnknownRendition
+		}
+		return format, nil
+	}
+
+	type candidate struct {
+		format renditionFormat
+		q      float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if raw, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(raw, 64); err == nil {
+				q = parsed
+			}
+		}
+		if format, ok := renditionForMediaType(mediaType, params["codecs"]); ok && q > 0 {
+			candidates = append(candidates, candidate{format: format, q: q})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return renditionMP3, nil
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].format, nil
+}
+
+func renditionForMediaType(mediaType, codecs string) (renditionFormat, bool) {
+	switch mediaType {
+	case "audio/mpeg", "audio/mp3":
+		return renditionMP3, true
+	case "audio/opus":
+		return renditionOpus, true
+	case "audio/ogg":
+		if strings.Contains(strings.ToLower(codecs), "opus") {
+			return renditionOpus, true
+		}
+		return renditionOgg, true
+	case "audio/wav", "audio/wave", "audio/x-wav":
+		return renditionWAV, true
+	}
+	return renditionFormat{}, false
+}
+
+// renditionCache guarda en disco las conversiones indexadas por checksum del
+// mp3 original, así un cambio en el sonido nunca sirve una versión vieja.
+type renditionCache struct {
+	dir      string
+	building *keyLocks
+}
+
+func newRenditionCache(dir string) (*renditionCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("no se pudo crear carpeta de conversiones: %w", err)
+	}
+
+	// Conversiones a medio escribir de una ejecución anterior.
+	if stale, err := filepath.Glob(filepath.Join(dir, ".tmp-*")); err == nil {
+		for _, path := range stale {
+			os.Remove(path)
+		}
+	}
+
+	return &renditionCache{dir: dir, building: newKeyLocks()}, nil
+}
+
+func (c *renditionCache) path(checksum string, format renditionFormat) string {
+	return filepath.Join(c.dir, checksum+format.Ext)
+}
+
+// lock serializa la generación de una misma conversión para que dos
+// descargas simultáneas no lancen dos ffmpeg.
+func (c *renditionCache) lock(key string) func() {
+	return c.building.lock(key)
+}
+
+// invalidate borra las conversiones de un contenido que ya no está en uso.
+func (c *renditionCache) invalidate(checksum string) {
+	if checksum == "" {
+		return
+	}
+	for _, format := range renditionsByName {
+		if err := os.Remove(c.path(checksum, format)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("no se pudo borrar conversión %s%s: %v", checksum, format.Ext, err)
+		}
+	}
+}
+
+// rendition devuelve la ruta de la conversión del sonido, generándola si
+// todavía no está en caché.
+func (s *server) rendition(ctx context.Context, name string, format renditionFormat) (string, error) {
+	checksum, err := s.soundChecksum(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	path := s.renditions.path(checksum, format)
+	unlock := s.renditions.lock(path)
+	defer unlock()
+
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	release, err := s.tryAcquireTranscode()
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	inputPath, err := s.downloadToTemp(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(inputPath)
+
+	tmp, err := os.CreateTemp(s.renditions.dir, ".tmp-*"+format.Ext)
+	if err != nil {
+		return "", fmt.Errorf("no se pudo preparar la conversión: %w", err)
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpPath)
+
+	if err := s.ffmpeg.transcode(ctx, inputPath, tmpPath, format.Args); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return "", fmt.Errorf("no se pudo guardar la conversión: %w", err)
+	}
+	return path, nil
+}
+
+func (s *server) soundChecksum(ctx context.Context, name string) (string, error) {
+	rec, err := s.findSound(ctx, name)
+	if err == nil && rec.Checksum != "" {
+		return rec.Checksum, nil
+	}
+	if err != nil && !errors.Is(err, errSoundNotFound) {
+		return "", err
+	}
+	return s.objectChecksum(ctx, name)
+}
+
+func (s *server) serveRendition(w http.ResponseWriter, r *http.Request, name string, format renditionFormat) {
+	path, err := s.rendition(r.Context(), name, format)
+	if errors.Is(err, errObjectNotFound) {
+		http.Error(w, "archivo no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error al convertir %s a %s: %v", name, format.Name, err)
+		writeProcessingError(w, err, "no se pudo convertir el archivo")
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("error al abrir conversión de %s: %v", name, err)
+		http.Error(w, "no se pudo abrir el archivo", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "no se pudo abrir el archivo", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType)
+	servedName := strings.TrimSuffix(name, filepath.Ext(name)) + format.Ext
+	http.ServeContent(w, r, servedName, info.ModTime(), f)
+}
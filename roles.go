@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type wasabiRole string
+
+const (
+	roleListener  wasabiRole = "listener"
+	roleUploader  wasabiRole = "uploader"
+	roleModerator wasabiRole = "moderator"
+	roleAdmin     wasabiRole = "admin"
+)
+
+var roleRank = map[wasabiRole]int{
+	roleListener:  1,
+	roleUploader:  2,
+	roleModerator: 3,
+	roleAdmin:     4,
+}
+
+func parseRole(raw string) (wasabiRole, error) {
+	role := wasabiRole(strings.ToLower(strings.TrimSpace(raw)))
+	if _, ok := roleRank[role]; !ok {
+		return "", fmt.Errorf("rol desconocido: %q", raw)
+	}
+	return role, nil
+}
+
+func (r wasabiRole) atLeast(min wasabiRole) bool {
+	return roleRank[r] >= roleRank[min]
+}
+
+// rolesAbove lista, ordenados, los roles de mayor rango que role.
+func rolesAbove(role wasabiRole) []string {
+	var above []string
+	for r, rank := range roleRank {
+		if rank > roleRank[role] {
+			above = append(above, string(r))
+		}
+	}
+	slices.Sort(above)
+	return above
+}
+
+// resolveRole elige el rol más alto entre los roles de Discord del miembro.
+func (a *authService) resolveRole(userID string, discordRoles []string) wasabiRole {
+	if a.adminUserIDs[userID] {
+		return roleAdmin
+	}
+
+	best := a.defaultRole
+	for _, id := range discordRoles {
+		if role, ok := a.roleByDiscordID[id]; ok && roleRank[role] > roleRank[best] {
+			best = role
+		}
+	}
+	return best
+}
+
+func (a *authService) roleOf(claims *jwtClaims) wasabiRole {
+	if a.adminUserIDs[claims.UserID] {
+		return roleAdmin
+	}
+	role, err := parseRole(claims.Role)
+	if err != nil {
+		return a.defaultRole
+	}
+	return role
+}
+
+func (a *authService) isElevated(claims *jwtClaims) bool {
+	return a.roleOf(claims).atLeast(roleModerator)
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
@@ -13,12 +14,41 @@ import (
 )
 
 type server struct {
-	uploadDir        string
-	auth             *authService
-	frontendOrigin   string
-	allowedOrigins   []string
-	mongoClient      *mongo.Client
-	introsCollection *mongo.Collection
+	store              storage
+	trashStore         storage
+	storageLabel       string
+	auth               *authService
+	frontendOrigin     string
+	allowedOrigins     []string
+	mongoClient        *mongo.Client
+	introsCollection   *mongo.Collection
+	soundsCollection   *mongo.Collection
+	trashCollection    *mongo.Collection
+	jobsCollection     *mongo.Collection
+	tokensCollection   *mongo.Collection
+	sessionsCollection *mongo.Collection
+
+	trashRetention     time.Duration
+	trashPurgeInterval time.Duration
+	uploadPolicy       uploadPolicy
+	loudnorm           loudnormConfig
+	ffmpeg             *ffmpegRunner
+	allowedFormats     []audioFormat
+	renditions         *renditionCache
+	tus                *tusStore
+	urlFetcher         *urlFetcher
+	membership         *membershipCache
+	verifyInterval     time.Duration
+
+	jobsDir    string
+	jobQueue   chan string
+	jobWorkers int
+	jobTimeout time.Duration
+	instanceID string
+	jobLease   time.Duration
+	// transcodeSlots limita los ffmpeg simultáneos, de trabajos y de
+	// peticiones, a TRANSCODE_WORKERS.
+	transcodeSlots chan struct{}
 }
 
 func newServer(cfg appConfig) (*server, error) {
@@ -34,36 +64,134 @@ func newServer(cfg appConfig) (*server, error) {
 		return nil, fmt.Errorf("no se pudo conectar a mongo: %w", err)
 	}
 
-	return &server{
-		uploadDir:        cfg.UploadDir,
-		auth:             newAuthService(cfg.Auth),
-		frontendOrigin:   cfg.FrontendOrigin,
-		allowedOrigins:   cfg.AllowedOrigins,
-		mongoClient:      client,
-		introsCollection: client.Database(cfg.Mongo.Database).Collection(cfg.Mongo.Collection),
-	}, nil
-}
+	store, err := newStorage(cfg.Storage, "")
+	if err != nil {
+		return nil, err
+	}
+
+	trashStore, err := newStorage(cfg.Storage, trashNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	jobsDir := filepath.Join(cfg.Jobs.DataDir, "jobs")
+	if err := os.MkdirAll(jobsDir, 0o755); err != nil {
+		return nil, fmt.Errorf("no se pudo crear carpeta de trabajos: %w", err)
+	}
+	removeStaleFiles(jobsDir, ".incoming-*")
+
+	ff, err := newFFmpegRunner(cfg.FFmpeg, filepath.Join(cfg.Jobs.DataDir, "tmp"))
+	if err != nil {
+		return nil, err
+	}
+
+	renditions, err := newRenditionCache(filepath.Join(cfg.Jobs.DataDir, "renditions"))
+	if err != nil {
+		return nil, err
+	}
 
-func (s *server) ensureUploadDir() error {
-	return os.MkdirAll(s.uploadDir, 0o755)
+	tus, err := newTusStore(filepath.Join(cfg.Jobs.DataDir, "tus"), cfg.Jobs.TusExpiration)
+	if err != nil {
+		return nil, err
+	}
+
+	db := client.Database(cfg.Mongo.Database)
+	srv := &server{
+		store:              store,
+		trashStore:         trashStore,
+		storageLabel:       describeStorage(cfg.Storage),
+		auth:               newAuthService(cfg.Auth),
+		frontendOrigin:     cfg.FrontendOrigin,
+		allowedOrigins:     cfg.AllowedOrigins,
+		mongoClient:        client,
+		introsCollection:   db.Collection(cfg.Mongo.Collection),
+		soundsCollection:   db.Collection(cfg.Mongo.SoundsCollection),
+		trashCollection:    db.Collection(cfg.Mongo.TrashCollection),
+		jobsCollection:     db.Collection(cfg.Mongo.JobsCollection),
+		tokensCollection:   db.Collection(cfg.Mongo.TokensCollection),
+		sessionsCollection: db.Collection(cfg.Mongo.SessionsCollection),
+
+		trashRetention:     cfg.Trash.Retention,
+		trashPurgeInterval: cfg.Trash.PurgeInterval,
+		uploadPolicy:       cfg.Upload,
+		loudnorm:           cfg.Loudnorm,
+		ffmpeg:             ff,
+		allowedFormats:     cfg.Formats,
+		renditions:         renditions,
+		tus:                tus,
+		urlFetcher:         newURLFetcher(cfg.URLImport),
+		membership:         newMembershipCache(cfg.Auth.VerifyCacheTTL),
+		verifyInterval:     cfg.Auth.VerifyInterval,
+
+		jobsDir:    jobsDir,
+		jobQueue:   make(chan string, cfg.Jobs.QueueSize),
+		jobWorkers: cfg.Jobs.Workers,
+		jobTimeout: cfg.Jobs.Timeout,
+		instanceID: cfg.Jobs.InstanceID,
+		jobLease:   cfg.Jobs.LeaseTTL,
+
+		transcodeSlots: make(chan struct{}, cfg.Jobs.Workers),
+	}
+
+	if err := srv.ensureSoundIndexes(ctx); err != nil {
+		return nil, fmt.Errorf("no se pudieron crear índices de sonidos: %w", err)
+	}
+	if err := srv.ensureTrashIndexes(ctx); err != nil {
+		return nil, fmt.Errorf("no se pudieron crear índices de la papelera: %w", err)
+	}
+	if err := srv.ensureJobIndexes(ctx); err != nil {
+		return nil, fmt.Errorf("no se pudieron crear índices de trabajos: %w", err)
+	}
+	if err := srv.ensureTokenIndexes(ctx); err != nil {
+		return nil, fmt.Errorf("no se pudieron crear índices de tokens: %w", err)
+	}
+	if err := srv.ensureSessionIndexes(ctx); err != nil {
+		return nil, fmt.Errorf("no se pudieron crear índices de sesiones: %w", err)
+	}
+
+	return srv, nil
 }
 
 func (s *server) routes() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/auth/discord", s.authDiscordHandler)
 	mux.HandleFunc("/auth/discord/callback", s.authCallbackHandler)
+	mux.HandleFunc("/auth/refresh", s.refreshHandler)
 	mux.HandleFunc("/auth/logout", s.logoutHandler)
 	mux.HandleFunc("/auth/me", s.authRequired(s.meHandler))
-	mux.HandleFunc("/upload", s.authRequired(s.uploadHandler))
-	mux.HandleFunc("/files", s.authRequired(s.listHandler))
-	mux.HandleFunc("/files/", s.authRequired(s.fileHandler))
-	mux.HandleFunc("/intro", s.authRequired(s.introHandler))
+	mux.HandleFunc("/auth/tokens", s.authRequired(requireSession(s.tokensHandler)))
+	mux.HandleFunc("/auth/tokens/", s.authRequired(requireSession(s.tokenHandler)))
+	mux.HandleFunc("/auth/sessions", s.authRequired(requireSession(s.sessionsHandler)))
+	mux.HandleFunc("/auth/sessions/", s.authRequired(requireSession(s.sessionHandler)))
+	mux.HandleFunc("/formats", s.authRequired(s.formatsHandler))
+	mux.HandleFunc("/upload", s.authRequired(s.requireScope(scopeFilesWrite, s.requireRole(roleUploader, s.uploadHandler))))
+	mux.HandleFunc("/upload/url", s.authRequired(s.requireScope(scopeFilesWrite, s.requireRole(roleUploader, s.urlImportHandler))))
+	mux.HandleFunc(tusBasePath, s.tusDiscovery(s.authRequired(s.requireScope(scopeFilesWrite, s.requireRole(roleUploader, s.tusHandler)))))
+	mux.HandleFunc("/files", s.authRequired(s.requireScope(scopeFilesRead, s.requireRole(roleListener, s.listHandler))))
+	mux.HandleFunc("/files/", s.authRequired(s.requireScopeForWrites(scopeFilesRead, scopeFilesWrite, s.requireRole(roleListener, s.requireRoleForWrites(roleUploader, s.fileHandler)))))
+	mux.HandleFunc("/trash", s.authRequired(s.requireRole(roleModerator, s.trashListHandler)))
+	mux.HandleFunc("/trash/", s.authRequired(s.requireRole(roleModerator, s.trashItemHandler)))
+	mux.HandleFunc("/jobs/", s.authRequired(s.requireScope(scopeFilesRead, s.requireRole(roleListener, s.jobHandler))))
+	mux.HandleFunc("/admin/export", s.authRequired(s.requireRole(roleAdmin, s.exportHandler)))
+	mux.HandleFunc("/admin/duplicates", s.authRequired(s.requireRole(roleAdmin, s.duplicatesHandler)))
+	mux.HandleFunc("/admin/import", s.authRequired(s.requireRole(roleAdmin, s.importHandler)))
+	mux.HandleFunc("/admin/users/", s.authRequired(s.requireRole(roleAdmin, s.userSessionsHandler)))
+	mux.HandleFunc("/intro", s.authRequired(s.requireScope(scopeIntroWrite, s.requireRole(roleListener, s.introHandler))))
 
 	return corsMiddleware(s.allowedOrigins, logRequest(mux))
 }
 
 func (s *server) listen(addr string) {
-	log.Printf("servidor escuchando en %s, carpeta de subidas: %s", addr, s.uploadDir)
+	go s.runTrashPurger(context.Background())
+	go s.runTusCleaner(context.Background())
+	if s.auth.botToken != "" {
+		go s.runGuildVerifier(context.Background())
+	} else {
+		log.Printf("DISCORD_BOT_TOKEN no configurado: la membresía solo se verifica al iniciar sesión y al renovar")
+	}
+	s.startJobWorkers(context.Background())
+
+	log.Printf("servidor escuchando en %s, almacenamiento: %s", addr, s.storageLabel)
 	if err := http.ListenAndServe(addr, s.routes()); err != nil {
 		log.Fatalf("servidor detenido: %v", err)
 	}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Margen en el que dos pestañas pueden renovar con el mismo refresh token
+// sin que cuente como reutilización.
+const refreshRaceWindow = 10 * time.Second
+
+var (
+	errSessionInvalid  = errors.New("sesión revocada o desconocida")
+	errSessionNotFound = errors.New("sesión no encontrada")
+	errRefreshInvalid  = errors.New("refresh token inválido o expirado")
+	errRefreshReused   = errors.New("refresh token reutilizado, se cerró la sesión")
+	errRefreshRace     = errors.New("la sesión ya se renovó")
+	errNotGuildMember  = errors.New("ya no eres miembro del servidor de Discord")
+)
+
+// session es el registro de un inicio de sesión y también la familia de sus
+// refresh tokens: su ID viaja como jti en cada access token y
+// RefreshGeneration dice cuál es el único refresh token válido. ExpiresAt se
+// corre con cada renovación. Las revocadas se conservan hasta que vencen para
+// que el índice TTL las borre junto con el resto.
+type session struct {
+	ID                  string     `bson:"_id" json:"id"`
+	UserID              string     `bson:"userId" json:"-"`
+	Username            string     `bson:"username" json:"-"`
+	Discriminator       string     `bson:"discriminator" json:"-"`
+	Avatar              string     `bson:"avatar" json:"-"`
+	Role                string     `bson:"role" json:"-"`
+	UserAgent           string     `bson:"userAgent" json:"userAgent"`
+	IP                  string     `bson:"ip" json:"ip"`
+	CreatedAt           time.Time  `bson:"createdAt" json:"createdAt"`
+	LastSeenAt          time.Time  `bson:"lastSeenAt" json:"lastSeenAt"`
+	ExpiresAt           time.Time  `bson:"expiresAt" json:"expiresAt"`
+	RefreshGeneration   int        `bson:"refreshGeneration" json:"-"`
+	RotatedAt           time.Time  `bson:"rotatedAt" json:"-"`
+	DiscordRefreshToken string     `bson:"discordRefreshToken,omitempty" json:"-"`
+	DiscordCheckedAt    time.Time  `bson:"discordCheckedAt" json:"-"`
+	RevokedAt           *time.Time `bson:"revokedAt,omitempty" json:"-"`
+	RevokedReason       string     `bson:"revokedReason,omitempty" json:"-"`
+	Current             bool       `bson:"-" json:"current"`
+}
+
+func (sess session) user() *discordUser {
+	return &discordUser{
+		ID:            sess.UserID,
+		Username:      sess.Username,
+		Discriminator: sess.Discriminator,
+		Avatar:        sess.Avatar,
+	}
+}
+
+func (s *server) ensureSessionIndexes(ctx context.Context) error {
+	_, err := s.sessionsCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (s *server) createSession(ctx context.Context, r *http.Request, user *discordUser, role wasabiRole, token *discordToken) (session, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return session{}, err
+	}
+	sealed, err := s.auth.sealToken(token.RefreshToken)
+	if err != nil {
+		return session{}, err
+	}
+
+	now := time.Now().UTC()
+	sess := session{
+		ID:                  id,
+		UserID:              user.ID,
+		Username:            user.Username,
+		Discriminator:       user.Discriminator,
+		Avatar:              user.Avatar,
+		Role:                string(role),
+		UserAgent:           r.UserAgent(),
+		IP:                  clientIP(r),
+		CreatedAt:           now,
+		LastSeenAt:          now,
+		ExpiresAt:           now.Add(s.auth.refreshTTL),
+		RotatedAt:           now,
+		DiscordRefreshToken: sealed,
+		DiscordCheckedAt:    now,
+	}
+	if _, err := s.sessionsCollection.InsertOne(ctx, sess); err != nil {
+		return session{}, err
+	}
+	return sess, nil
+}
+
+// refreshSession canjea un refresh token por la siguiente generación. Un
+// token de una generación anterior significa que alguien lo copió, así que se
+// revoca la sesión entera. Cada DISCORD_RECHECK_INTERVAL se vuelve a consultar
+// Discord para confirmar la membresía y actualizar el rol.
+func (s *server) refreshSession(ctx context.Context, token string) (session, error) {
+	id, gen, err := s.auth.parseRefreshToken(token)
+	if err != nil {
+		return session{}, err
+	}
+
+	var sess session
+	err = s.sessionsCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&sess)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return session{}, errRefreshInvalid
+	}
+	if err != nil {
+		return session{}, err
+	}
+
+	now := time.Now().UTC()
+	if sess.RevokedAt != nil || !sess.ExpiresAt.After(now) {
+		return session{}, errRefreshInvalid
+	}
+	if s.membership.rejects(sess.UserID) {
+		s.revokeSessionByID(ctx, sess.ID, "guild")
+		return session{}, errNotGuildMember
+	}
+	if gen == sess.RefreshGeneration-1 && now.Sub(sess.RotatedAt) < refreshRaceWindow {
+		return session{}, errRefreshRace
+	}
+	if gen != sess.RefreshGeneration {
+		s.revokeReusedSession(ctx, sess.ID)
+		return session{}, errRefreshReused
+	}
+
+	// El filtro por generación hace que de dos renovaciones simultáneas con el
+	// mismo token solo gane una, y solo esa consulta a Discord.
+	expires := now.Add(s.auth.refreshTTL)
+	res, err := s.sessionsCollection.UpdateOne(ctx,
+		bson.M{"_id": sess.ID, "refreshGeneration": gen, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{
+			"refreshGeneration": gen + 1,
+			"rotatedAt":         now,
+			"lastSeenAt":        now,
+			"expiresAt":         expires,
+		}},
+	)
+	if err != nil {
+		return session{}, err
+	}
+	if res.MatchedCount == 0 {
+		return session{}, errRefreshRace
+	}
+	sess.RefreshGeneration = gen + 1
+	sess.ExpiresAt = expires
+
+	if now.Sub(sess.DiscordCheckedAt) < s.auth.recheckInterval {
+		return sess, nil
+	}
+	err = s.recheckDiscord(ctx, &sess)
+	if errors.Is(err, errRefreshInvalid) || errors.Is(err, errNotGuildMember) {
+		return session{}, err
+	}
+	if err != nil {
+		// Una caída de Discord no cierra sesiones; se reintenta en la próxima
+		// renovación.
+		log.Printf("no se pudo verificar la sesión %s con Discord: %v", sess.ID, err)
+		return sess, nil
+	}
+
+	_, err = s.sessionsCollection.UpdateOne(ctx, bson.M{"_id": sess.ID}, bson.M{"$set": bson.M{
+		"username":         sess.Username,
+		"discriminator":    sess.Discriminator,
+		"avatar":           sess.Avatar,
+		"role":             sess.Role,
+		"discordCheckedAt": now,
+	}})
+	if err != nil {
+		log.Printf("no se pudo actualizar la sesión %s: %v", sess.ID, err)
+	}
+	return sess, nil
+}
+
+// recheckDiscord renueva el token de Discord de la sesión y vuelve a leer el
+// perfil, la membresía y los roles. Si Discord revocó la autorización o el
+// usuario dejó el servidor, la sesión se revoca.
+func (s *server) recheckDiscord(ctx context.Context, sess *session) error {
+	refreshToken, err := s.auth.openToken(sess.DiscordRefreshToken)
+	if err != nil {
+		s.revokeSessionByID(ctx, sess.ID, "discord")
+		return errRefreshInvalid
+	}
+
+	token, err := s.auth.refreshDiscordToken(refreshToken)
+	if errors.Is(err, errDiscordGrantRevoked) {
+		log.Printf("Discord rechazó el refresh token de la sesión %s: %v", sess.ID, err)
+		s.revokeSessionByID(ctx, sess.ID, "discord")
+		return errRefreshInvalid
+	}
+	if err != nil {
+		return fmt.Errorf("no se pudo renovar el token de Discord: %w", err)
+	}
+
+	// Discord ya invalidó el refresh token anterior: el nuevo se guarda
+	// aunque después falle algo, para no perder la sesión.
+	sealed, err := s.auth.sealToken(token.RefreshToken)
+	if err != nil {
+		return err
+	}
+	sess.DiscordRefreshToken = sealed
+	if _, err := s.sessionsCollection.UpdateOne(ctx, bson.M{"_id": sess.ID}, bson.M{"$set": bson.M{"discordRefreshToken": sealed}}); err != nil {
+		return fmt.Errorf("no se pudo guardar el token de Discord: %w", err)
+	}
+
+	user, err := s.auth.fetchDiscordUser(token.AccessToken)
+	if err != nil {
+		return fmt.Errorf("no se pudo obtener el perfil: %w", err)
+	}
+	isMember, err := s.auth.isMemberOfRequiredGuild(token.AccessToken)
+	if err != nil {
+		return fmt.Errorf("no se pudo verificar membresía: %w", err)
+	}
+	if !isMember {
+		s.membership.set(sess.UserID, memberLeft)
+		s.revokeSessionByID(ctx, sess.ID, "guild")
+		return errNotGuildMember
+	}
+	s.membership.set(sess.UserID, memberActive)
+	discordRoles, err := s.auth.fetchGuildMemberRoles(token.AccessToken)
+	if err != nil {
+		return fmt.Errorf("no se pudieron obtener los roles: %w", err)
+	}
+
+	sess.Username = user.Username
+	sess.Discriminator = user.Discriminator
+	sess.Avatar = user.Avatar
+	role := s.auth.resolveRole(user.ID, discordRoles)
+	sess.Role = string(role)
+	if err := s.capUserRole(ctx, sess.UserID, role, sess.ID); err != nil {
+		log.Printf("%v", err)
+	}
+	return nil
+}
+
+// capUserRole aplica el rol actual del usuario a lo que ya tiene emitido:
+// baja los tokens de API y revoca las sesiones guardadas con un rol mayor,
+// así quien pierde roles en Discord tiene que volver a iniciar sesión.
+// exceptID es la sesión que se está renovando, que ya toma el rol nuevo.
+func (s *server) capUserRole(ctx context.Context, userID string, role wasabiRole, exceptID string) error {
+	if err := s.capUserAPITokens(ctx, userID, role); err != nil {
+		return err
+	}
+	above := rolesAbove(role)
+	if len(above) == 0 {
+		return nil
+	}
+
+	filter := bson.M{"userId": userID, "role": bson.M{"$in": above}, "revokedAt": bson.M{"$exists": false}}
+	if exceptID != "" {
+		filter["_id"] = bson.M{"$ne": exceptID}
+	}
+	res, err := s.sessionsCollection.UpdateMany(ctx, filter,
+		bson.M{"$set": bson.M{"revokedAt": time.Now().UTC(), "revokedReason": "role_lowered"}},
+	)
+	if err != nil {
+		return fmt.Errorf("no se pudieron revocar las sesiones de %s: %w", userID, err)
+	}
+	if res.ModifiedCount > 0 {
+		log.Printf("sesiones: user_id=%s rol=%s revocadas=%d", userID, role, res.ModifiedCount)
+	}
+	return nil
+}
+
+func (s *server) revokeReusedSession(ctx context.Context, id string) {
+	log.Printf("refresh token reutilizado: se revoca la sesión %s", id)
+	s.revokeSessionByID(ctx, id, "refresh_reuse")
+}
+
+func (s *server) revokeSessionByID(ctx context.Context, id, reason string) {
+	_, err := s.sessionsCollection.UpdateOne(ctx,
+		bson.M{"_id": id, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now().UTC(), "revokedReason": reason}},
+	)
+	if err != nil {
+		log.Printf("no se pudo revocar la sesión %s: %v", id, err)
+	}
+}
+
+// checkSession confirma que el jti del JWT sigue vivo. Los JWT sin jti son de
+// antes de que existieran las sesiones y se rechazan.
+func (s *server) checkSession(ctx context.Context, claims *jwtClaims) error {
+	if claims.ID == "" {
+		return errSessionInvalid
+	}
+
+	var sess session
+	err := s.sessionsCollection.FindOne(ctx, bson.M{"_id": claims.ID, "userId": claims.UserID}).Decode(&sess)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return errSessionInvalid
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if sess.RevokedAt != nil || !sess.ExpiresAt.After(now) {
+		return errSessionInvalid
+	}
+
+	// Igual que con los tokens, basta con registrar la actividad cada minuto.
+	if now.Sub(sess.LastSeenAt) > time.Minute {
+		if _, err := s.sessionsCollection.UpdateOne(ctx, bson.M{"_id": sess.ID}, bson.M{"$set": bson.M{"lastSeenAt": now}}); err != nil {
+			log.Printf("no se pudo registrar actividad de la sesión %s: %v", sess.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *server) listSessions(ctx context.Context, userID, currentID string) ([]session, error) {
+	filter := bson.M{
+		"userId":    userID,
+		"revokedAt": bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": time.Now().UTC()},
+	}
+	cursor, err := s.sessionsCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "lastSeenAt", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	sessions := []session{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentID
+	}
+	return sessions, nil
+}
+
+func (s *server) revokeSession(ctx context.Context, userID, id, reason string) error {
+	res, err := s.sessionsCollection.UpdateOne(ctx,
+		bson.M{"_id": id, "userId": userID, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now().UTC(), "revokedReason": reason}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errSessionNotFound
+	}
+	return nil
+}
+
+// revokeUserSessions revoca todas las sesiones del usuario salvo exceptID.
+func (s *server) revokeUserSessions(ctx context.Context, userID, exceptID, reason string) (int64, error) {
+	filter := bson.M{"userId": userID, "revokedAt": bson.M{"$exists": false}}
+	if exceptID != "" {
+		filter["_id"] = bson.M{"$ne": exceptID}
+	}
+	res, err := s.sessionsCollection.UpdateMany(ctx, filter,
+		bson.M{"$set": bson.M{"revokedAt": time.Now().UTC(), "revokedReason": reason}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
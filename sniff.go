@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var errUnsupportedFormat = errors.New("formato no soportado")
+
+// sniffLen alcanza para las firmas de contenedor que reconocemos, incluida la
+// cabecera de Opus dentro de la primera página Ogg y el DocType de EBML.
+const sniffLen = 64
+
+// audioFormat es el formato real de un archivo según su contenido; Ext es la
+// extensión con la que se procesa, sin importar cómo se llamaba al subirlo.
+// En los formatos de video solo se conserva la pista de audio.
+type audioFormat struct {
+	Name       string   `json:"name"`
+	Ext        string   `json:"-"`
+	Extensions []string `json:"extensions"`
+	MimeTypes  []string `json:"mimeTypes"`
+	Video      bool     `json:"video"`
+}
+
+var (
+	formatMP3  = audioFormat{Name: "mp3", Ext: ".mp3", Extensions: []string{".mp3"}, MimeTypes: []string{"audio/mpeg"}}
+	formatOgg  = audioFormat{Name: "ogg", Ext: ".ogg", Extensions: []string{".ogg", ".oga"}, MimeTypes: []string{"audio/ogg"}}
+	formatOpus = audioFormat{Name: "opus", Ext: ".opus", Extensions: []string{".opus"}, MimeTypes: []string{"audio/opus", "audio/ogg"}}
+	formatWAV  = audioFormat{Name: "wav", Ext: ".wav", Extensions: []string{".wav"}, MimeTypes: []string{"audio/wav", "audio/x-wav"}}
+	formatFLAC = audioFormat{Name: "flac", Ext: ".flac", Extensions: []string{".flac"}, MimeTypes: []string{"audio/flac"}}
+	formatM4A  = audioFormat{Name: "m4a", Ext: ".m4a", Extensions: []string{".m4a"}, MimeTypes: []string{"audio/mp4", "audio/x-m4a"}}
+	formatMP4  = audioFormat{Name: "mp4", Ext: ".mp4", Extensions: []string{".mp4", ".m4v"}, MimeTypes: []string{"video/mp4"}, Video: true}
+	formatMOV  = audioFormat{Name: "mov", Ext: ".mov", Extensions: []string{".mov"}, MimeTypes: []string{"video/quicktime"}, Video: true}
+	formatWebM = audioFormat{Name: "webm", Ext: ".webm", Extensions: []string{".webm"}, MimeTypes: []string{"audio/webm", "video/webm"}, Video: true}
+	formatMKV  = audioFormat{Name: "mkv", Ext: ".mkv", Extensions: []string{".mkv", ".mka"}, MimeTypes: []string{"video/x-matroska", "audio/x-matroska"}, Video: true}
+)
+
+// knownFormats en el orden en que se publican en /formats.
+var knownFormats = []audioFormat{
+	formatMP3, formatOgg, formatOpus, formatWAV, formatFLAC, formatM4A,
+	formatMP4, formatMOV, formatWebM, formatMKV,
+}
+
+func formatByName(name string) (audioFormat, bool) {
+	for _, format := range knownFormats {
+		if format.Name == name {
+			return format, true
+		}
+	}
+	return audioFormat{}, false
+}
+
+// sniffAudioFormat reconoce el contenedor por sus primeros bytes.
+func sniffAudioFormat(head []byte) (audioFormat, bool) {
+	switch {
+	case bytes.HasPrefix(head, []byte("ID3")):
+		return formatMP3, true
+	case isMPEGFrameSync(head):
+		return formatMP3, true
+	case bytes.HasPrefix(head, []byte("OggS")):
+		if bytes.Contains(head, []byte("OpusHead")) {
+			return formatOpus, true
+		}
+		return formatOgg, true
+	case len(head) >= 12 && bytes.Equal(head[0:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WAVE")):
+		return formatWAV, true
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return formatFLAC, true
+	case len(head) >= 12 && bytes.Equal(head[4:8], []byte("ftyp")):
+		return sniffISOBrand(head[8:12]), true
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		if bytes.Contains(head, []byte("webm")) {
+			return formatWebM, true
+		}
+		return formatMKV, true
+	}
+	return audioFormat{}, false
+}
+
+// sniffISOBrand distingue por la marca principal de ftyp entre audio M4A,
+// video MP4 y QuickTime; todos se decodifican igual con ffmpeg.
+func sniffISOBrand(brand []byte) audioFormat {
+	switch string(brand) {
+	case "M4A ", "M4B ", "M4P ":
+		return formatM4A
+	case "qt  ":
+		return formatMOV
+	default:
+		return formatMP4
+	}
+}
+
+// isMPEGFrameSync revisa la cabecera de un frame MPEG de audio sin ID3:
+// 11 bits de sincronía, versión y capa válidas. La capa 0 es AAC (ADTS).
+func isMPEGFrameSync(head []byte) bool {
+	if len(head) < 2 || head[0] != 0xFF || head[1]&0xE0 != 0xE0 {
+		return false
+	}
+	version := (head[1] >> 3) & 0x03
+	layer := (head[1] >> 1) & 0x03
+	return version != 0x01 && layer != 0x00
+}
+
+func sniffFile(path string) (audioFormat, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return audioFormat{}, fmt.Errorf("no se pudo leer la subida: %w", err)
+	}
+	defer f.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return audioFormat{}, fmt.Errorf("no se pudo leer la subida: %w", err)
+	}
+
+	format, ok := sniffAudioFormat(head[:n])
+	if !ok {
+		return audioFormat{}, errUnsupportedFormat
+	}
+	return format, nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// soundProcessing marca un nombre reservado cuya conversión sigue en curso.
+const soundProcessing = "processing"
+
+var (
+	errSoundNotFound = errors.New("sonido no encontrado en el catálogo")
+	errSoundExists   = errors.New("ya existe un sonido con ese nombre")
+)
+
+type soundRecord struct {
+	Name           string          `bson:"name"`
+	Title          string          `bson:"title"`
+	Status         string          `bson:"status,omitempty"`
+	OriginalName   string          `bson:"originalName"`
+	SourceChecksum string          `bson:"sourceChecksum,omitempty"`
+	OriginalFormat string          `bson:"originalFormat"`
+	DetectedFormat string          `bson:"detectedFormat,omitempty"`
+	UploaderID     string          `bson:"uploaderId"`
+	UploaderName   string          `bson:"uploaderName"`
+	Size           int64           `bson:"size"`
+	Audio          audioInfo       `bson:",inline"`
+	Checksum       string          `bson:"checksum"`
+	Loudness       *loudnessRecord `bson:"loudness,omitempty"`
+	Peaks          []float64       `bson:"peaks,omitempty"`
+	UploadedAt     time.Time       `bson:"uploadedAt"`
+	ModifiedAt     time.Time       `bson:"modifiedAt"`
+}
+
+func (rec soundRecord) entry() fileEntry {
+	return fileEntry{
+		Name:           rec.Name,
+		Title:          rec.Title,
+		Size:           rec.Size,
+		Modified:       rec.ModifiedAt.Format(time.RFC3339),
+		UploadedAt:     rec.UploadedAt.Format(time.RFC3339),
+		UploaderID:     rec.UploaderID,
+		UploaderName:   rec.UploaderName,
+		OriginalName:   rec.OriginalName,
+		OriginalFormat: rec.OriginalFormat,
+		DetectedFormat: rec.DetectedFormat,
+		Duration:       rec.Audio.Duration,
+		SampleRate:     rec.Audio.SampleRate,
+		Channels:       rec.Audio.Channels,
+		Bitrate:        rec.Audio.Bitrate,
+		Codec:          rec.Audio.Codec,
+		Checksum:       rec.Checksum,
+		Loudness:       rec.Loudness,
+	}
+}
+
+func (s *server) canManageSound(claims *jwtClaims, rec soundRecord) bool {
+	if s.auth.isElevated(claims) {
+		return true
+	}
+	return rec.UploaderID != "" && rec.UploaderID == claims.UserID
+}
+
+func titleFromName(name string) string {
+	title := strings.TrimSuffix(name, filepath.Ext(name))
+	if title == "" {
+		return name
+	}
+	return title
+}
+
+func (s *server) ensureSoundIndexes(ctx context.Context) error {
+	_, err := s.soundsCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "sourceChecksum", Value: 1}}},
+		{Keys: bson.D{{Key: "checksum", Value: 1}}},
+	})
+	return err
+}
+
+func (s *server) insertSound(ctx context.Context, rec soundRecord) error {
+	_, err := s.soundsCollection.InsertOne(ctx, rec)
+	if mongo.IsDuplicateKeyError(err) {
+		return errSoundExists
+	}
+	return err
+}
+
+func (s *server) findSound(ctx context.Context, name string) (soundRecord, error) {
+	var rec soundRecord
+	err := s.soundsCollection.FindOne(ctx, bson.M{"name": name}).Decode(&rec)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return soundRecord{}, errSoundNotFound
+	}
+	return rec, err
+}
+
+func (s *server) listSounds(ctx context.Context) ([]soundRecord, error) {
+	return s.findSounds(ctx, bson.M{})
+}
+
+func readySounds(filter bson.M) bson.M {
+	filter["status"] = bson.M{"$ne": soundProcessing}
+	return filter
+}
+
+func (s *server) findSounds(ctx context.Context, filter bson.M) ([]soundRecord, error) {
+	// Los picos de la forma de onda se piden aparte en /files/{nombre}/waveform.
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}}).SetProjection(bson.M{"peaks": 0})
+	cursor, err := s.soundsCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []soundRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (s *server) renameSound(ctx context.Context, oldName, newName, title string) error {
+	rec, err := s.findSound(ctx, oldName)
+	if err != nil {
+		return err
+	}
+
+	if title == "" {
+		title = rec.Title
+		if title == "" || title == titleFromName(oldName) {
+			title = titleFromName(newName)
+		}
+	}
+
+	_, err = s.soundsCollection.UpdateOne(ctx, bson.M{"name": oldName}, bson.M{"$set": bson.M{
+		"name":       newName,
+		"title":      title,
+		"modifiedAt": time.Now().UTC(),
+	}})
+	if mongo.IsDuplicateKeyError(err) {
+		return errSoundExists
+	}
+	return err
+}
+
+func (s *server) updateSoundAudio(ctx context.Context, name string, stored storedAudio) error {
+	set := bson.M{
+		"size":       stored.Size,
+		"checksum":   stored.Checksum,
+		"duration":   stored.Audio.Duration,
+		"sampleRate": stored.Audio.SampleRate,
+		"channels":   stored.Audio.Channels,
+		"bitrate":    stored.Audio.Bitrate,
+		"codec":      stored.Audio.Codec,
+		"modifiedAt": time.Now().UTC(),
+	}
+	unset := bson.M{"status": ""}
+	if stored.Loudness != nil {
+		set["loudness"] = stored.Loudness
+	} else {
+		unset["loudness"] = ""
+	}
+	if len(stored.Peaks) > 0 {
+		set["peaks"] = stored.Peaks
+	} else {
+		unset["peaks"] = ""
+	}
+	update := bson.M{"$set": set, "$unset": unset}
+
+	var previous soundRecord
+	err := s.soundsCollection.FindOneAndUpdate(ctx, bson.M{"name": name}, update).Decode(&previous)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return errSoundNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if previous.Checksum != stored.Checksum {
+		s.renditions.invalidate(previous.Checksum)
+	}
+	return nil
+}
+
+func (s *server) deleteSound(ctx context.Context, name string) error {
+	_, err := s.soundsCollection.DeleteOne(ctx, bson.M{"name": name})
+	return err
+}
+
+func (s *server) releaseSoundReservation(ctx context.Context, name string) error {
+	_, err := s.soundsCollection.DeleteOne(ctx, bson.M{"name": name, "status": soundProcessing})
+	return err
+}
+
+// syncSoundCatalog registra los archivos que ya estaban en el almacenamiento
+// antes del catálogo y descarta registros cuyo archivo ya no existe.
+func (s *server) syncSoundCatalog(ctx context.Context) error {
+	objects, err := s.store.List(ctx)
+	if err != nil {
+		return fmt.Errorf("no se pudo listar almacenamiento: %w", err)
+	}
+
+	records, err := s.listSounds(ctx)
+	if err != nil {
+		return fmt.Errorf("no se pudo leer el catálogo: %w", err)
+	}
+
+	known := make(map[string]bool, len(records))
+	for _, rec := range records {
+		known[rec.Name] = true
+	}
+
+	present := make(map[string]bool, len(objects))
+	for _, obj := range objects {
+		present[obj.Name] = true
+		if known[obj.Name] {
+			continue
+		}
+
+		checksum, err := s.objectChecksum(ctx, obj.Name)
+		if err != nil {
+			log.Printf("no se pudo calcular checksum de %s: %v", obj.Name, err)
+		}
+
+		err = s.insertSound(ctx, soundRecord{
+			Name:           obj.Name,
+			Title:          titleFromName(obj.Name),
+			OriginalName:   obj.Name,
+			OriginalFormat: strings.TrimPrefix(strings.ToLower(filepath.Ext(obj.Name)), "."),
+			Size:           obj.Size,
+			Checksum:       checksum,
+			UploadedAt:     obj.Modified.UTC(),
+			ModifiedAt:     obj.Modified.UTC(),
+		})
+		if err != nil && !errors.Is(err, errSoundExists) {
+			return fmt.Errorf("no se pudo registrar %s: %w", obj.Name, err)
+		}
+		log.Printf("sonido existente agregado al catálogo: %s", obj.Name)
+	}
+
+	for _, rec := range records {
+		if present[rec.Name] || rec.Status == soundProcessing {
+			continue
+		}
+		if err := s.deleteSound(ctx, rec.Name); err != nil {
+			return fmt.Errorf("no se pudo quitar %s del catálogo: %w", rec.Name, err)
+		}
+		log.Printf("sonido sin archivo quitado del catálogo: %s", rec.Name)
+	}
+
+	return nil
+}
+
+func (s *server) objectChecksum(ctx context.Context, name string) (string, error) {
+	content, _, err := s.store.Get(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
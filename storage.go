@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+var (
+	errObjectNotFound = errors.New("archivo no encontrado")
+	errObjectInvalid  = errors.New("nombre inválido")
+	errObjectExists   = errors.New("ya existe un archivo con ese nombre")
+)
+
+type storageObject struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+}
+
+// storage guarda los sonidos por nombre. Put reemplaza el objeto si ya
+// existe: que dos subidas no usen el mismo nombre lo garantiza la reserva en
+// el catálogo (índice único de Mongo), no el almacenamiento. Rename en cambio
+// nunca pisa un destino existente y devuelve errObjectExists; en local es
+// atómico, en S3 se comprueba antes de copiar.
+type storage interface {
+	Put(ctx context.Context, name string, src io.Reader) error
+	Get(ctx context.Context, name string) (io.ReadCloser, storageObject, error)
+	Stat(ctx context.Context, name string) (storageObject, error)
+	List(ctx context.Context) ([]storageObject, error)
+	Rename(ctx context.Context, oldName, newName string) error
+	Delete(ctx context.Context, name string) error
+}
+
+// newStorage abre el almacenamiento configurado; namespace permite separar
+// áreas internas (como la papelera) que no aparecen al listar los sonidos.
+func newStorage(cfg storageConfig, namespace string) (storage, error) {
+	switch cfg.Backend {
+	case "", "local":
+		return newLocalStorage(filepath.Join(cfg.LocalDir, namespace))
+	case "s3":
+		s3cfg := cfg.S3
+		if namespace != "" {
+			s3cfg.Prefix += namespace + "/"
+		}
+		return newS3Storage(s3cfg)
+	default:
+		return nil, fmt.Errorf("backend de almacenamiento desconocido: %s", cfg.Backend)
+	}
+}
+
+func (s *server) objectExists(ctx context.Context, name string) (bool, error) {
+	_, err := s.store.Stat(ctx, name)
+	if errors.Is(err, errObjectNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func describeStorage(cfg storageConfig) string {
+	if cfg.Backend == "s3" {
+		return fmt.Sprintf("s3 %s/%s/%s", cfg.S3.Endpoint, cfg.S3.Bucket, cfg.S3.Prefix)
+	}
+	return "carpeta " + cfg.LocalDir
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type localStorage struct {
+	dir string
+}
+
+func newLocalStorage(dir string) (*localStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("no se pudo crear la carpeta de subida: %w", err)
+	}
+	return &localStorage{dir: dir}, nil
+}
+
+func (l *localStorage) path(name string) string {
+	return filepath.Join(l.dir, name)
+}
+
+func (l *localStorage) Put(ctx context.Context, name string, src io.Reader) error {
+	tmp, err := os.CreateTemp(l.dir, ".tmp-put-*")
+	if err != nil {
+		return fmt.Errorf("no se pudo preparar el destino temporal: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return fmt.Errorf("no se pudo escribir el archivo: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("no se pudo cerrar el archivo: %w", err)
+	}
+	if err := os.Chmod(tmpPath, 0o644); err != nil {
+		return fmt.Errorf("no se pudieron ajustar permisos: %w", err)
+	}
+	if err := os.Rename(tmpPath, l.path(name)); err != nil {
+		return fmt.Errorf("no se pudo mover el archivo: %w", err)
+	}
+	return nil
+}
+
+func (l *localStorage) Get(ctx context.Context, name string) (io.ReadCloser, storageObject, error) {
+	obj, err := l.Stat(ctx, name)
+	if err != nil {
+		return nil, storageObject{}, err
+	}
+
+	f, err := os.Open(l.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, storageObject{}, errObjectNotFound
+	}
+	if err != nil {
+		return nil, storageObject{}, err
+	}
+	return f, obj, nil
+}
+
+func (l *localStorage) Stat(ctx context.Context, name string) (storageObject, error) {
+	info, err := os.Stat(l.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return storageObject{}, errObjectNotFound
+	}
+	if err != nil {
+		return storageObject{}, err
+	}
+	if info.IsDir() {
+		return storageObject{}, errObjectInvalid
+	}
+	return storageObject{Name: name, Size: info.Size(), Modified: info.ModTime()}, nil
+}
+
+func (l *localStorage) List(ctx context.Context) ([]storageObject, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []storageObject
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		objects = append(objects, storageObject{Name: e.Name(), Size: info.Size(), Modified: info.ModTime()})
+	}
+	return objects, nil
+}
+
+func (l *localStorage) Rename(ctx context.Context, oldName, newName string) error {
+	if _, err := l.Stat(ctx, oldName); err != nil {
+		return err
+	}
+	// os.Rename pisaría el destino; Link falla si ya existe.
+	if err := os.Link(l.path(oldName), l.path(newName)); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return errObjectExists
+		}
+		return err
+	}
+	return os.Remove(l.path(oldName))
+}
+
+func (l *localStorage) Delete(ctx context.Context, name string) error {
+	if _, err := l.Stat(ctx, name); err != nil {
+		return err
+	}
+	err := os.Remove(l.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return errObjectNotFound
+	}
+	return err
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	s3UnsignedPayload  = "UNSIGNED-PAYLOAD"
+)
+
+type s3Storage struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	prefix    string
+	pathStyle bool
+	client    *http.Client
+}
+
+func newS3Storage(cfg s3Config) (*s3Storage, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT inválido: %q", cfg.Endpoint)
+	}
+
+	return &s3Storage{
+		endpoint:  endpoint,
+		region:    cfg.Region,
+		bucket:    cfg.Bucket,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		prefix:    cfg.Prefix,
+		pathStyle: cfg.PathStyle,
+		client:    &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+func (s *s3Storage) key(name string) string {
+	return s.prefix + name
+}
+
+func (s *s3Storage) Put(ctx context.Context, name string, src io.Reader) error {
+	body, size, cleanup, err := sizedReader(src)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	resp, err := s.do(ctx, http.MethodPut, s.key(name), nil, body, size, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return s3CheckResponse(resp)
+}
+
+func (s *s3Storage) Get(ctx context.Context, name string) (io.ReadCloser, storageObject, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.key(name), nil, nil, 0, nil)
+	if err != nil {
+		return nil, storageObject{}, err
+	}
+	if err := s3CheckResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, storageObject{}, err
+	}
+	return resp.Body, s3ObjectFromHeaders(name, resp), nil
+}
+
+func (s *s3Storage) Stat(ctx context.Context, name string) (storageObject, error) {
+	resp, err := s.do(ctx, http.MethodHead, s.key(name), nil, nil, 0, nil)
+	if err != nil {
+		return storageObject{}, err
+	}
+	defer resp.Body.Close()
+	if err := s3CheckResponse(resp); err != nil {
+		return storageObject{}, err
+	}
+	return s3ObjectFromHeaders(name, resp), nil
+}
+
+func (s *s3Storage) List(ctx context.Context) ([]storageObject, error) {
+	var objects []storageObject
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("delimiter", "/")
+		if s.prefix != "" {
+			query.Set("prefix", s.prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := s3CheckResponse(resp); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+
+		var result struct {
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+			Contents              []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("respuesta de listado inválida: %w", err)
+		}
+
+		for _, c := range result.Contents {
+			name := strings.TrimPrefix(c.Key, s.prefix)
+			if name == "" || strings.Contains(name, "/") || strings.HasPrefix(name, ".") {
+				continue
+			}
+			objects = append(objects, storageObject{Name: name, Size: c.Size, Modified: c.LastModified})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	return objects, nil
+}
+
+func (s *s3Storage) Rename(ctx context.Context, oldName, newName string) error {
+	if _, err := s.Stat(ctx, oldName); err != nil {
+		return err
+	}
+	// S3 no tiene copia condicional; el catálogo ya reservó newName, esto solo
+	// evita pisar un objeto huérfano.
+	if _, err := s.Stat(ctx, newName); err == nil {
+		return errObjectExists
+	} else if !errors.Is(err, errObjectNotFound) {
+		return err
+	}
+
+	headers := http.Header{}
+	headers.Set("x-amz-copy-source", "/"+s.bucket+"/"+s3EncodePath(s.key(oldName)))
+	resp, err := s.do(ctx, http.MethodPut, s.key(newName), nil, nil, 0, headers)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := s3CheckResponse(resp); err != nil {
+		return err
+	}
+
+	// CopyObject puede responder 200 con un error en el cuerpo.
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return fmt.Errorf("no se pudo leer respuesta de copia: %w", err)
+	}
+	if bytes.Contains(body, []byte("<Error>")) {
+		return fmt.Errorf("s3 no pudo copiar el objeto: %s", body)
+	}
+
+	return s.Delete(ctx, oldName)
+}
+
+func (s *s3Storage) Delete(ctx context.Context, name string) error {
+	if _, err := s.Stat(ctx, name); err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodDelete, s.key(name), nil, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return s3CheckResponse(resp)
+}
+
+func (s *s3Storage) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	basePath := strings.TrimSuffix(u.Path, "/")
+
+	var rawPath string
+	if s.pathStyle {
+		rawPath = basePath + "/" + s3EncodePath(s.bucket)
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		rawPath = basePath
+	}
+	if key != "" || !s.pathStyle {
+		rawPath += "/" + s3EncodePath(key)
+	}
+
+	decoded, _ := url.PathUnescape(rawPath)
+	u.Path = decoded
+	u.RawPath = rawPath
+	u.RawQuery = s3CanonicalQuery(query)
+	return &u
+}
+
+func (s *s3Storage) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64, headers http.Header) (*http.Response, error) {
+	u := s.objectURL(key, query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("error al crear petición s3: %w", err)
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for k, values := range headers {
+		for _, v := range values {
+			req.Header.Add(k, v)
+		}
+	}
+
+	payloadHash := s3EmptyPayloadHash
+	if body != nil {
+		payloadHash = s3UnsignedPayload
+	}
+	s.sign(req, u, payloadHash, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error en petición s3: %w", err)
+	}
+	return resp, nil
+}
+
+func (s *s3Storage) sign(req *http.Request, u *url.URL, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signed := map[string]string{"host": u.Host}
+	for k, values := range req.Header {
+		lower := strings.ToLower(k)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" {
+			signed[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for k := range signed {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + signed[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		u.EscapedPath(),
+		u.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func s3EncodePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func s3Escape(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3CheckResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return errObjectNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return fmt.Errorf("s3 respondió %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func s3ObjectFromHeaders(name string, resp *http.Response) storageObject {
+	obj := storageObject{Name: name, Size: resp.ContentLength}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		obj.Modified = modified
+	}
+	return obj
+}
+
+// sizedReader devuelve un lector con tamaño conocido; S3 no acepta cuerpos
+// sin Content-Length, así que los lectores de tamaño desconocido se vuelcan a disco.
+func sizedReader(src io.Reader) (io.Reader, int64, func(), error) {
+	if seeker, ok := src.(io.ReadSeeker); ok {
+		current, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			end, err := seeker.Seek(0, io.SeekEnd)
+			if err == nil {
+				if _, err := seeker.Seek(current, io.SeekStart); err == nil {
+					return seeker, end - current, func() {}, nil
+				}
+			}
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "s3-put-*")
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("no se pudo preparar el archivo temporal: %w", err)
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+
+	size, err := io.Copy(tmp, src)
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, fmt.Errorf("no se pudo guardar el archivo temporal: %w", err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, 0, nil, fmt.Errorf("no se pudo leer el archivo temporal: %w", err)
+	}
+	return tmp, size, cleanup, nil
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	fakeS3Bucket    = "sonidos"
+	fakeS3AccessKey = "fake-access"
+	fakeS3PageSize  = 2
+)
+
+// fakeS3 imita lo que usa s3Storage de un servidor compatible (MinIO) con
+// direcciones path-style: objetos, copia con x-amz-copy-source y
+// ListObjectsV2 paginado de a fakeS3PageSize claves.
+type fakeS3 struct {
+	*httptest.Server
+
+	t        *testing.T
+	mu       sync.Mutex
+	objects  map[string][]byte
+	modified time.Time
+	pages    int
+}
+
+func newFakeS3(t *testing.T) *fakeS3 {
+	t.Helper()
+
+	f := &fakeS3{
+		t:        t,
+		objects:  make(map[string][]byte),
+		modified: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeS3) storage(prefix string) *s3Storage {
+	f.t.Helper()
+	store, err := newS3Storage(s3Config{
+		Endpoint:  f.URL,
+		Region:    "us-east-1",
+		Bucket:    fakeS3Bucket,
+		AccessKey: fakeS3AccessKey,
+		SecretKey: "fake-secret",
+		Prefix:    prefix,
+		PathStyle: true,
+	})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return store
+}
+
+func (f *fakeS3) object(key string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.objects[key]
+}
+
+func (f *fakeS3) serve(w http.ResponseWriter, r *http.Request) {
+	// Solo se revisa que la firma esté presente y bien formada.
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential="+fakeS3AccessKey+"/") ||
+		!fakeS3Signed(auth, r.Header) || !strings.Contains(auth, "Signature=") ||
+		r.Header.Get("x-amz-date") == "" || r.Header.Get("x-amz-content-sha256") == "" {
+		f.t.Errorf("%s %s sin firma válida: %q", r.Method, r.URL.Path, auth)
+		http.Error(w, "<Error><Code>AccessDenied</Code></Error>", http.StatusForbidden)
+		return
+	}
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+fakeS3Bucket)
+	if !ok {
+		http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+		return
+	}
+	key = strings.TrimPrefix(key, "/")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if key == "" && r.Method == http.MethodGet {
+		f.list(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if source := r.Header.Get("x-amz-copy-source"); source != "" {
+			data, ok := f.objects[strings.TrimPrefix(source, "/"+fakeS3Bucket+"/")]
+			if !ok {
+				http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+				return
+			}
+			f.objects[key] = data
+			io.WriteString(w, "<CopyObjectResult></CopyObjectResult>")
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil || int64(len(data)) != r.ContentLength {
+			http.Error(w, "<Error><Code>IncompleteBody</Code></Error>", http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = data
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Last-Modified", f.modified.Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "método no soportado", http.StatusMethodNotAllowed)
+	}
+}
+
+// fakeS3Signed revisa que la firma cubra host y todas las cabeceras x-amz-*.
+func fakeS3Signed(auth string, header http.Header) bool {
+	_, rest, ok := strings.Cut(auth, "SignedHeaders=")
+	if !ok {
+		return false
+	}
+	list, _, _ := strings.Cut(rest, ",")
+	signed := make(map[string]bool)
+	for _, name := range strings.Split(list, ";") {
+		signed[name] = true
+	}
+	if !signed["host"] {
+		return false
+	}
+	for name := range header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") && !signed[lower] {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("list-type") != "2" || q.Get("delimiter") != "/" {
+		http.Error(w, "<Error><Code>InvalidArgument</Code></Error>", http.StatusBadRequest)
+		return
+	}
+	f.pages++
+
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, q.Get("prefix")) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	// El token es la última clave devuelta, como hace MinIO en la práctica.
+	start := 0
+	if token := q.Get("continuation-token"); token != "" {
+		start = sort.SearchStrings(keys, token) + 1
+	}
+	end := min(start+fakeS3PageSize, len(keys))
+
+	type content struct {
+		Key          string
+		Size         int
+		LastModified time.Time
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []content
+	}{IsTruncated: end < len(keys)}
+	for _, key := range keys[start:end] {
+		result.Contents = append(result.Contents, content{key, len(f.objects[key]), f.modified})
+	}
+	if result.IsTruncated {
+		result.NextContinuationToken = keys[end-1]
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func TestS3Storage(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeS3(t)
+	store := fake.storage("intros/")
+
+	readAll := func(name string) string {
+		t.Helper()
+		body, obj, err := store.Get(ctx, name)
+		if err != nil {
+			t.Fatalf("Get(%s): %v", name, err)
+		}
+		defer body.Close()
+		data, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj.Size != int64(len(data)) {
+			t.Errorf("Get(%s) tamaño %d, se leyeron %d bytes", name, obj.Size, len(data))
+		}
+		return string(data)
+	}
+
+	// Un lector sin Seek pasa por el archivo temporal de sizedReader.
+	if err := store.Put(ctx, "hola.mp3", io.MultiReader(strings.NewReader("ho"), strings.NewReader("la"))); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if got := readAll("hola.mp3"); got != "hola" {
+		t.Errorf("Get = %q, se esperaba %q", got, "hola")
+	}
+	if fake.object("intros/hola.mp3") == nil {
+		t.Error("Put no usó el prefijo")
+	}
+
+	obj, err := store.Stat(ctx, "hola.mp3")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if obj.Size != 4 || !obj.Modified.Equal(fake.modified) {
+		t.Errorf("Stat = %+v", obj)
+	}
+	if _, err := store.Stat(ctx, "nada.mp3"); !errors.Is(err, errObjectNotFound) {
+		t.Errorf("Stat de un objeto inexistente: %v", err)
+	}
+
+	t.Run("List", func(t *testing.T) {
+		for _, name := range []string{"b.mp3", "c.mp3", "d.mp3", "e.mp3"} {
+			if err := store.Put(ctx, name, strings.NewReader(name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Fuera del prefijo, en una subcarpeta u oculto: no se listan.
+		fake.mu.Lock()
+		fake.objects["otros/x.mp3"] = []byte("x")
+		fake.objects["intros/sub/y.mp3"] = []byte("y")
+		fake.objects["intros/.tmp"] = []byte("z")
+		fake.pages = 0
+		fake.mu.Unlock()
+
+		objects, err := store.List(ctx)
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		var names []string
+		for _, o := range objects {
+			names = append(names, o.Name)
+		}
+		want := "b.mp3 c.mp3 d.mp3 e.mp3 hola.mp3"
+		if got := strings.Join(names, " "); got != want {
+			t.Errorf("List = %q, se esperaba %q", got, want)
+		}
+		fake.mu.Lock()
+		pages := fake.pages
+		fake.mu.Unlock()
+		if pages < 3 {
+			t.Errorf("List pidió %d páginas, se esperaba seguir el continuation-token", pages)
+		}
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		if err := store.Rename(ctx, "hola.mp3", "chau.mp3"); err != nil {
+			t.Fatalf("Rename: %v", err)
+		}
+		if got := readAll("chau.mp3"); got != "hola" {
+			t.Errorf("después de Rename = %q", got)
+		}
+		if _, err := store.Stat(ctx, "hola.mp3"); !errors.Is(err, errObjectNotFound) {
+			t.Errorf("el original sigue existiendo: %v", err)
+		}
+		if err := store.Rename(ctx, "chau.mp3", "b.mp3"); !errors.Is(err, errObjectExists) {
+			t.Errorf("Rename sobre un destino existente: %v", err)
+		}
+		if got := readAll("b.mp3"); got != "b.mp3" {
+			t.Errorf("Rename pisó el destino: %q", got)
+		}
+		if err := store.Rename(ctx, "nada.mp3", "otro.mp3"); !errors.Is(err, errObjectNotFound) {
+			t.Errorf("Rename de un objeto inexistente: %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := store.Delete(ctx, "chau.mp3"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := store.Stat(ctx, "chau.mp3"); !errors.Is(err, errObjectNotFound) {
+			t.Errorf("el objeto sigue existiendo: %v", err)
+		}
+		if err := store.Delete(ctx, "chau.mp3"); !errors.Is(err, errObjectNotFound) {
+			t.Errorf("Delete de un objeto inexistente: %v", err)
+		}
+	})
+}
+
+func TestLocalStorageRenameKeepsTarget(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := newLocalStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string]string{"a.mp3": "a", "b.mp3": "b"} {
+		if err := store.Put(ctx, name, strings.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Rename(ctx, "a.mp3", "b.mp3"); !errors.Is(err, errObjectExists) {
+		t.Fatalf("Rename sobre un destino existente: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "b.mp3")); string(data) != "b" {
+		t.Errorf("Rename pisó el destino: %q", data)
+	}
+	if err := store.Rename(ctx, "a.mp3", "c.mp3"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.mp3")); !os.IsNotExist(err) {
+		t.Errorf("el original sigue existiendo: %v", err)
+	}
+}
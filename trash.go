@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const trashNamespace = ".trash"
+
+var errTrashNotFound = errors.New("el sonido no está en la papelera")
+
+// Cada eliminación es una entrada aparte, aunque se repita el nombre: el
+// archivo se guarda en la papelera con una clave propia (Object).
+type trashEntry struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty"`
+	Name          string             `bson:"name"`
+	Object        string             `bson:"object"`
+	Sound         soundRecord        `bson:"sound"`
+	DeletedByID   string             `bson:"deletedById"`
+	DeletedByName string             `bson:"deletedByName"`
+	DeletedAt     time.Time          `bson:"deletedAt"`
+	PurgeAt       time.Time          `bson:"purgeAt"`
+}
+
+type trashItem struct {
+	ID            string  `json:"id"`
+	Name          string  `json:"name"`
+	Title         string  `json:"title"`
+	Size          int64   `json:"size"`
+	Duration      float64 `json:"duration"`
+	UploaderID    string  `json:"uploaderId,omitempty"`
+	UploaderName  string  `json:"uploaderName,omitempty"`
+	DeletedByID   string  `json:"deletedById"`
+	DeletedByName string  `json:"deletedByName"`
+	DeletedAt     string  `json:"deletedAt"`
+	PurgeAt       string  `json:"purgeAt"`
+}
+
+func (e trashEntry) item() trashItem {
+	return trashItem{
+		ID:            e.ID.Hex(),
+		Name:          e.Name,
+		Title:         e.Sound.Title,
+		Size:          e.Sound.Size,
+		Duration:      e.Sound.Audio.Duration,
+		UploaderID:    e.Sound.UploaderID,
+		UploaderName:  e.Sound.UploaderName,
+		DeletedByID:   e.DeletedByID,
+		DeletedByName: e.DeletedByName,
+		DeletedAt:     e.DeletedAt.Format(time.RFC3339),
+		PurgeAt:       e.PurgeAt.Format(time.RFC3339),
+	}
+}
+
+func (s *server) ensureTrashIndexes(ctx context.Context) error {
+	_, err := s.trashCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "name", Value: 1}, {Key: "deletedAt", Value: -1}}},
+		{Keys: bson.D{{Key: "purgeAt", Value: 1}}},
+	})
+	return err
+}
+
+// moveToTrash saca el sonido de la biblioteca sin borrarlo. Otros sonidos
+// eliminados antes con el mismo nombre siguen en la papelera.
+func (s *server) moveToTrash(ctx context.Context, name string, claims *jwtClaims) error {
+	rec, err := s.findSound(ctx, name)
+	if errors.Is(err, errSoundNotFound) {
+		obj, statErr := s.store.Stat(ctx, name)
+		if statErr != nil {
+			return statErr
+		}
+		rec = soundRecord{Name: name, Title: titleFromName(name), Size: obj.Size, UploadedAt: obj.Modified, ModifiedAt: obj.Modified}
+	} else if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	id := primitive.NewObjectID()
+	entry := trashEntry{
+		ID:            id,
+		Name:          name,
+		Object:        id.Hex() + "-" + name,
+		Sound:         rec,
+		DeletedByID:   claims.UserID,
+		DeletedByName: claims.Username,
+		DeletedAt:     now,
+		PurgeAt:       now.Add(s.trashRetention),
+	}
+
+	if err := copyObject(ctx, s.store, s.trashStore, name, entry.Object); err != nil {
+		return err
+	}
+	if _, err := s.trashCollection.InsertOne(ctx, entry); err != nil {
+		s.discardTrashObject(ctx, entry)
+		return fmt.Errorf("no se pudo registrar en la papelera: %w", err)
+	}
+	if err := s.store.Delete(ctx, name); err != nil {
+		if _, delErr := s.trashCollection.DeleteOne(ctx, bson.M{"_id": id}); delErr != nil {
+			log.Printf("error al revertir %s de la papelera: %v", name, delErr)
+		}
+		s.discardTrashObject(ctx, entry)
+		return err
+	}
+
+	return s.deleteSound(ctx, name)
+}
+
+func (s *server) discardTrashObject(ctx context.Context, entry trashEntry) {
+	if err := s.trashStore.Delete(ctx, entry.Object); err != nil && !errors.Is(err, errObjectNotFound) {
+		log.Printf("error al borrar %s de la papelera: %v", entry.Object, err)
+	}
+}
+
+// findTrashEntry busca la entrada más reciente con ese nombre, o la indicada
+// por id si hay varias.
+func (s *server) findTrashEntry(ctx context.Context, name, id string) (trashEntry, error) {
+	filter := bson.M{"name": name}
+	if id != "" {
+		oid, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return trashEntry{}, errTrashNotFound
+		}
+		filter["_id"] = oid
+	}
+
+	var entry trashEntry
+	err := s.trashCollection.FindOne(ctx, filter, options.FindOne().SetSort(bson.D{{Key: "deletedAt", Value: -1}})).Decode(&entry)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return trashEntry{}, errTrashNotFound
+	}
+	return entry, err
+}
+
+func (s *server) restoreFromTrash(ctx context.Context, name, id string) (soundRecord, error) {
+	entry, err := s.findTrashEntry(ctx, name, id)
+	if err != nil {
+		return soundRecord{}, err
+	}
+
+	taken, err := s.targetTaken(ctx, entry.Name)
+	if err != nil {
+		return soundRecord{}, err
+	}
+	if taken {
+		return soundRecord{}, errSoundExists
+	}
+
+	rec := entry.Sound
+	rec.ModifiedAt = time.Now().UTC()
+	if err := s.insertSound(ctx, rec); err != nil {
+		return soundRecord{}, err
+	}
+
+	if err := copyObject(ctx, s.trashStore, s.store, entry.Object, entry.Name); err != nil {
+		if delErr := s.deleteSound(ctx, entry.Name); delErr != nil {
+			log.Printf("error al revertir restauración de %s: %v", entry.Name, delErr)
+		}
+		return soundRecord{}, err
+	}
+
+	s.discardTrashObject(ctx, entry)
+	if _, err := s.trashCollection.DeleteOne(ctx, bson.M{"_id": entry.ID}); err != nil {
+		log.Printf("error al quitar %s de la papelera: %v", entry.Name, err)
+	}
+
+	return rec, nil
+}
+
+func (s *server) listTrash(ctx context.Context) ([]trashEntry, error) {
+	cursor, err := s.trashCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "deletedAt", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []trashEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// purgeTrashEntry borra primero el archivo: si falla, la entrada sigue y la
+// próxima purga lo reintenta.
+func (s *server) purgeTrashEntry(ctx context.Context, entry trashEntry) error {
+	if err := s.trashStore.Delete(ctx, entry.Object); err != nil && !errors.Is(err, errObjectNotFound) {
+		return err
+	}
+	res, err := s.trashCollection.DeleteOne(ctx, bson.M{"_id": entry.ID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return errTrashNotFound
+	}
+	s.renditions.invalidate(entry.Sound.Checksum)
+	return nil
+}
+
+func (s *server) purgeExpiredTrash(ctx context.Context) (int, error) {
+	cursor, err := s.trashCollection.Find(ctx, bson.M{"purgeAt": bson.M{"$lte": time.Now().UTC()}})
+	if err != nil {
+		return 0, err
+	}
+
+	var expired []trashEntry
+	if err := cursor.All(ctx, &expired); err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, entry := range expired {
+		if err := s.purgeTrashEntry(ctx, entry); err != nil && !errors.Is(err, errTrashNotFound) {
+			log.Printf("error al purgar %s de la papelera: %v", entry.Name, err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+func (s *server) runTrashPurger(ctx context.Context) {
+	ticker := time.NewTicker(s.trashPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purgeCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		purged, err := s.purgeExpiredTrash(purgeCtx)
+		cancel()
+		if err != nil {
+			log.Printf("error al purgar la papelera: %v", err)
+		} else if purged > 0 {
+			log.Printf("papelera: %d sonidos purgados", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func copyObject(ctx context.Context, from, to storage, fromName, toName string) error {
+	content, _, err := from.Get(ctx, fromName)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	if err := to.Put(ctx, toName, content); err != nil {
+		return fmt.Errorf("no se pudo copiar %s: %w", fromName, err)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+	tusBasePath   = "/upload/tus/"
+
+	tusRequestHeaders  = "Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata"
+	tusResponseHeaders = "Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Offset, Upload-Length, Upload-Expires, Wasabi-Job-Id, Wasabi-Sound-Name, Wasabi-Duplicate-Of"
+)
+
+var (
+	errTusNotFound       = errors.New("subida no encontrada")
+	errTusOffsetMismatch = errors.New("el offset no coincide con lo recibido")
+)
+
+// tusUpload es el estado de una subida reanudable; se guarda como JSON junto
+// al archivo parcial para sobrevivir reinicios.
+type tusUpload struct {
+	ID        string            `json:"id"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"offset"`
+	Metadata  map[string]string `json:"metadata"`
+	UserID    string            `json:"userId"`
+	Target    string            `json:"target"`
+	CreatedAt time.Time         `json:"createdAt"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+
+	// HashState es el estado del SHA-256 hasta Offset, para seguir
+	// calculándolo entre fragmentos sin volver a leer lo ya recibido.
+	HashState []byte `json:"hashState,omitempty"`
+}
+
+type tusStore struct {
+	dir        string
+	expiration time.Duration
+
+	locks *keyLocks
+}
+
+func newTusStore(dir string, expiration time.Duration) (*tusStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("no se pudo crear carpeta de subidas reanudables: %w", err)
+	}
+	return &tusStore{dir: dir, expiration: expiration, locks: newKeyLocks()}, nil
+}
+
+func newTusID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (t *tusStore) dataPath(id string) string { return filepath.Join(t.dir, id) }
+func (t *tusStore) infoPath(id string) string { return filepath.Join(t.dir, id+".json") }
+
+// lock evita que dos PATCH sobre la misma subida escriban a la vez. El
+// candado se descarta al soltarlo si nadie más lo espera, así pedir IDs
+// inexistentes no deja entradas.
+func (t *tusStore) lock(id string) func() {
+	return t.locks.lock(id)
+}
+
+func (t *tusStore) create(upload tusUpload) error {
+	f, err := os.OpenFile(t.dataPath(upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("no se pudo crear la subida: %w", err)
+	}
+	f.Close()
+
+	if err := t.save(upload); err != nil {
+		os.Remove(t.dataPath(upload.ID))
+		return err
+	}
+	return nil
+}
+
+func (t *tusStore) load(id string) (tusUpload, error) {
+	raw, err := os.ReadFile(t.infoPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return tusUpload{}, errTusNotFound
+	}
+	if err != nil {
+		return tusUpload{}, err
+	}
+
+	var upload tusUpload
+	if err := json.Unmarshal(raw, &upload); err != nil {
+		return tusUpload{}, fmt.Errorf("estado de subida inválido: %w", err)
+	}
+	return upload, nil
+}
+
+// save reemplaza el JSON de forma atómica para no dejarlo a medias.
+func (t *tusStore) save(upload tusUpload) error {
+	raw, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+
+	tmp := t.infoPath(upload.ID) + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return fmt.Errorf("no se pudo guardar el estado de la subida: %w", err)
+	}
+	return os.Rename(tmp, t.infoPath(upload.ID))
+}
+
+// appendChunk escribe desde el offset actual hasta el largo declarado; lo
+// que sobre del cuerpo se ignora.
+func (t *tusStore) appendChunk(upload *tusUpload, body io.Reader) error {
+	f, err := os.OpenFile(t.dataPath(upload.ID), os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("no se pudo abrir la subida: %w", err)
+	}
+	defer f.Close()
+
+	// Si un PATCH anterior se cortó después de escribir pero antes de guardar
+	// el estado, se descarta lo que quedó más allá del offset.
+	if err := f.Truncate(upload.Offset); err != nil {
+		return fmt.Errorf("no se pudo preparar la subida: %w", err)
+	}
+	if _, err := f.Seek(upload.Offset, io.SeekStart); err != nil {
+		return fmt.Errorf("no se pudo preparar la subida: %w", err)
+	}
+
+	h, err := upload.hash()
+	if err != nil {
+		return err
+	}
+
+	n, copyErr := io.Copy(io.MultiWriter(f, h), io.LimitReader(body, upload.Length-upload.Offset))
+	upload.Offset += n
+	upload.ExpiresAt = time.Now().UTC().Add(t.expiration)
+	if upload.HashState, err = h.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+		return fmt.Errorf("no se pudo guardar el estado de la subida: %w", err)
+	}
+
+	if err := t.save(*upload); err != nil {
+		return err
+	}
+	if copyErr != nil {
+		return fmt.Errorf("subida interrumpida: %w", copyErr)
+	}
+	return nil
+}
+
+// hash retoma el SHA-256 en Offset. Con datos recibidos el estado tiene que
+// estar guardado.
+func (u tusUpload) hash() (hash.Hash, error) {
+	h := sha256.New()
+	if len(u.HashState) == 0 {
+		if u.Offset > 0 {
+			return nil, errors.New("estado de subida inválido: falta el estado del checksum")
+		}
+		return h, nil
+	}
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(u.HashState); err != nil {
+		return nil, fmt.Errorf("estado de subida inválido: %w", err)
+	}
+	return h, nil
+}
+
+// checksum devuelve el SHA-256 de lo recibido.
+func (u tusUpload) checksum() (string, error) {
+	h, err := u.hash()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (t *tusStore) remove(id string) {
+	for _, path := range []string{t.dataPath(id), t.infoPath(id)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("no se pudo borrar %s: %v", path, err)
+		}
+	}
+}
+
+func (t *tusStore) removeExpired() int {
+	matches, err := filepath.Glob(filepath.Join(t.dir, "*.json"))
+	if err != nil {
+		return 0
+	}
+
+	removed := 0
+	now := time.Now().UTC()
+	for _, path := range matches {
+		id := strings.TrimSuffix(filepath.Base(path), ".json")
+		upload, err := t.load(id)
+		if err != nil || upload.ExpiresAt.After(now) {
+			continue
+		}
+		unlock := t.lock(id)
+		t.remove(id)
+		unlock()
+		removed++
+	}
+	return removed
+}
+
+func (s *server) runTusCleaner(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		if removed := s.tus.removeExpired(); removed > 0 {
+			log.Printf("subidas reanudables: %d vencidas borradas", removed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// parseTusMetadata lee Upload-Metadata: pares "clave valorBase64" separados
+// por comas.
+func parseTusMetadata(raw string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range splitList(raw) {
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("Upload-Metadata inválido para %q", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadUploadFormLimitsEachPart(t *testing.T) {
+	dir := t.TempDir()
+	s := &server{jobsDir: dir, uploadPolicy: uploadPolicy{MaxFileSize: 16, MaxBatchSize: 64}}
+
+	small := bytes.Repeat([]byte{1}, 10)
+	archive := append([]byte("PK\x03\x04"), bytes.Repeat([]byte{2}, 36)...)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("title", "Hola")
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{
+		{"chico.mp3", small},
+		{"grande.mp3", bytes.Repeat([]byte{3}, 40)},
+		{"sonidos.zip", archive},
+	} {
+		w, _ := mw.CreateFormFile("file", f.name)
+		w.Write(f.data)
+	}
+	mw.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	parts, fields, err := s.readUploadForm(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields["title"] != "Hola" || len(parts) != 3 {
+		t.Fatalf("fields=%v parts=%+v", fields, parts)
+	}
+
+	sum := sha256.Sum256(small)
+	if p := parts[0]; p.TooLarge || p.Zip || p.Size != 10 || p.Checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("chico.mp3 = %+v", p)
+	}
+	// Un audio se corta apenas pasa MaxFileSize aunque quepa en el total.
+	if p := parts[1]; !p.TooLarge || p.Path != "" || p.Size != 17 {
+		t.Errorf("grande.mp3 = %+v", p)
+	}
+	// Un ZIP solo se limita por MaxBatchSize.
+	if p := parts[2]; !p.Zip || p.TooLarge || p.Size != int64(len(archive)) {
+		t.Errorf("sonidos.zip = %+v", p)
+	}
+
+	removeUploadParts(parts)
+	if left, _ := os.ReadDir(dir); len(left) != 0 {
+		t.Errorf("quedaron %d temporales", len(left))
+	}
+}
+
+// Un archivo suelto gasta del total de la petición, y un ZIP posterior solo
+// dispone de lo que queda.
+func TestBatchBudgetCountsPlainParts(t *testing.T) {
+	s := &server{
+		uploadPolicy:   uploadPolicy{MaxFileSize: 16, MaxBatchSize: 24, MaxBatchFiles: 10},
+		allowedFormats: []audioFormat{{Name: "mp3", Extensions: []string{".mp3"}}},
+	}
+
+	// Ya se aceptaron 10 bytes; 16 más pasan del total.
+	budget := &batchBudget{files: 1, bytes: 10}
+	res := s.ingestBatchPart(context.Background(), nil, uploadPart{FileName: "b.mp3", Size: 16}, budget)
+	if res.Status != batchRejected || res.Violations[0].Code != "batch_too_large" || budget.bytes != 26 {
+		t.Fatalf("archivo suelto = %+v, presupuesto %d", res, budget.bytes)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("c.mp3")
+	w.Write(bytes.Repeat([]byte{1}, 4))
+	zw.Close()
+	path := filepath.Join(t.TempDir(), "sonidos.zip")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	results := s.ingestZip(context.Background(), nil, uploadPart{FileName: "sonidos.zip", Path: path, Size: int64(buf.Len()), Zip: true}, budget)
+	if len(results) != 1 || results[0].Status != batchRejected || results[0].Violations[0].Code != "batch_too_large" {
+		t.Fatalf("ZIP = %+v", results)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type uploadPolicy struct {
+	MaxDuration        time.Duration
+	MaxFileSize        int64
+	AllowedSampleRates []int
+	MaxLoudnessLUFS    *float64
+	MaxTruePeak        *float64
+	MaxBatchFiles      int
+	MaxBatchSize       int64
+	Duplicates         string
+}
+
+type policyViolation struct {
+	Code    string   `bson:"code" json:"code"`
+	Message string   `bson:"message" json:"message"`
+	Limit   *float64 `bson:"limit,omitempty" json:"limit,omitempty"`
+	Actual  float64  `bson:"actual" json:"actual"`
+	Allowed []int    `bson:"allowed,omitempty" json:"allowed,omitempty"`
+}
+
+type policyError struct {
+	violations []policyViolation
+}
+
+func (e *policyError) Error() string {
+	codes := make([]string, 0, len(e.violations))
+	for _, v := range e.violations {
+		codes = append(codes, v.Code)
+	}
+	return "el archivo no cumple la política de subida: " + strings.Join(codes, ", ")
+}
+
+func (e *policyError) status() int {
+	for _, v := range e.violations {
+		if v.Code == "file_too_large" || v.Code == "batch_too_large" {
+			return http.StatusRequestEntityTooLarge
+		}
+	}
+	return http.StatusUnprocessableEntity
+}
+
+func (p uploadPolicy) fileTooLarge(size int64) *policyError {
+	return &policyError{violations: []policyViolation{{
+		Code:    "file_too_large",
+		Message: fmt.Sprintf("el archivo supera el máximo de %d bytes", p.MaxFileSize),
+		Limit:   floatPtr(float64(p.MaxFileSize)),
+		Actual:  float64(size),
+	}}}
+}
+
+func (p uploadPolicy) batchTooLarge(size int64) *policyError {
+	return &policyError{violations: []policyViolation{{
+		Code:    "batch_too_large",
+		Message: fmt.Sprintf("la subida supera el máximo de %d bytes por petición", p.MaxBatchSize),
+		Limit:   floatPtr(float64(p.MaxBatchSize)),
+		Actual:  float64(size),
+	}}}
+}
+
+// check valida el archivo ya analizado con las reglas baratas de evaluar,
+// para poder rechazarlo durante la misma petición.
+func (p uploadPolicy) check(size int64, info audioInfo) error {
+	var violations []policyViolation
+
+	if p.MaxFileSize > 0 && size > p.MaxFileSize {
+		violations = append(violations, p.fileTooLarge(size).violations...)
+	}
+
+	if p.MaxDuration > 0 && info.Duration > p.MaxDuration.Seconds() {
+		violations = append(violations, policyViolation{
+			Code:    "duration_exceeded",
+			Message: fmt.Sprintf("el audio dura más de %s", p.MaxDuration),
+			Limit:   floatPtr(p.MaxDuration.Seconds()),
+			Actual:  info.Duration,
+		})
+	}
+
+	if len(p.AllowedSampleRates) > 0 && !containsInt(p.AllowedSampleRates, info.SampleRate) {
+		violations = append(violations, policyViolation{
+			Code:    "sample_rate_not_allowed",
+			Message: fmt.Sprintf("frecuencia de muestreo %d Hz no permitida", info.SampleRate),
+			Actual:  float64(info.SampleRate),
+			Allowed: p.AllowedSampleRates,
+		})
+	}
+
+	if len(violations) > 0 {
+		return &policyError{violations: violations}
+	}
+	return nil
+}
+
+func (p uploadPolicy) checksLoudness() bool {
+	return p.MaxLoudnessLUFS != nil || p.MaxTruePeak != nil
+}
+
+// checkLoudness decodifica el audio completo para medirlo, por eso se
+// ejecuta en el worker de conversión y no en la petición de subida.
+func (p uploadPolicy) checkLoudness(ctx context.Context, ff *ffmpegRunner, path string) error {
+	if !p.checksLoudness() {
+		return nil
+	}
+
+	stats, err := ff.measureLoudness(ctx, path, loudnormTarget{})
+	if err != nil {
+		return err
+	}
+
+	var violations []policyViolation
+	if p.MaxLoudnessLUFS != nil && stats.IntegratedLUFS > *p.MaxLoudnessLUFS {
+		violations = append(violations, policyViolation{
+			Code:    "loudness_exceeded",
+			Message: fmt.Sprintf("sonoridad integrada mayor a %.1f LUFS", *p.MaxLoudnessLUFS),
+			Limit:   p.MaxLoudnessLUFS,
+			Actual:  stats.IntegratedLUFS,
+		})
+	}
+	if p.MaxTruePeak != nil && stats.TruePeak > *p.MaxTruePeak {
+		violations = append(violations, policyViolation{
+			Code:    "true_peak_exceeded",
+			Message: fmt.Sprintf("pico real mayor a %.1f dBTP", *p.MaxTruePeak),
+			Limit:   p.MaxTruePeak,
+			Actual:  stats.TruePeak,
+		})
+	}
+
+	if len(violations) > 0 {
+		return &policyError{violations: violations}
+	}
+	return nil
+}
+
+func writePolicyRejection(w http.ResponseWriter, err *policyError) {
+	writeJSON(w, err.status(), map[string]any{
+		"error":      "upload_rejected",
+		"message":    err.Error(),
+		"violations": err.violations,
+	})
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+func containsInt(values []int, v int) bool {
+	for _, item := range values {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func parseByteSize(raw string) (int64, error) {
+	raw = strings.ToUpper(strings.TrimSpace(raw))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		factor int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(raw, unit.suffix) {
+			multiplier = unit.factor
+			raw = strings.TrimSpace(strings.TrimSuffix(raw, unit.suffix))
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("tamaño inválido")
+	}
+	return n * multiplier, nil
+}
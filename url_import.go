@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	errURLBlocked         = errors.New("la URL apunta a una dirección no permitida")
+	errURLInvalid         = errors.New("URL inválida, usa http o https")
+	errURLContentType     = errors.New("la URL no devolvió un archivo de audio o video")
+	errURLDownloadFailed  = errors.New("no se pudo descargar la URL")
+	errURLTooManyRedirect = errors.New("demasiadas redirecciones")
+)
+
+type urlImportConfig struct {
+	Timeout      time.Duration
+	MaxSize      int64
+	AllowCIDRs   []*net.IPNet
+	DenyCIDRs    []*net.IPNet
+	ContentTypes []string
+}
+
+// addressPolicy decide a qué IPs se puede conectar. Lo permitido
+// explícitamente gana; después se bloquea lo denegado y cualquier dirección
+// que no sea pública.
+type addressPolicy struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// Rangos no públicos que net.IP no clasifica por sí mismo.
+var reservedNets = mustParseCIDRs("0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "198.18.0.0/15", "240.0.0.0/4", "64:ff9b::/96")
+
+func (p addressPolicy) allowed(ip net.IP) bool {
+	for _, n := range p.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	for _, n := range p.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	for _, n := range reservedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast())
+}
+
+// control se ejecuta con la IP ya resuelta justo antes de conectar, así un
+// DNS que cambia entre la validación y la conexión no sirve para saltarla.
+func (p addressPolicy) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !p.allowed(ip) {
+		return fmt.Errorf("%w: %s", errURLBlocked, host)
+	}
+	return nil
+}
+
+// urlFetcher descarga archivos remotos con límites de tiempo, tamaño y tipo.
+// El cliente se arma aparte para poder apuntarlo a un httptest.Server
+// permitiendo 127.0.0.0/8 en la política.
+type urlFetcher struct {
+	client       *http.Client
+	maxSize      int64
+	contentTypes []string
+}
+
+func newURLFetcher(cfg urlImportConfig) *urlFetcher {
+	policy := addressPolicy{allow: cfg.AllowCIDRs, deny: cfg.DenyCIDRs}
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: policy.control}
+
+	transport := &http.Transport{
+		// Sin proxy: la política tiene que ver la IP real del destino.
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: cfg.Timeout,
+		MaxIdleConns:          4,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	return &urlFetcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 5 {
+					return errURLTooManyRedirect
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return errURLInvalid
+				}
+				return nil
+			},
+		},
+		maxSize:      cfg.MaxSize,
+		contentTypes: cfg.ContentTypes,
+	}
+}
+
+func parseImportURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return nil, errURLInvalid
+	}
+	return u, nil
+}
+
+func (f *urlFetcher) acceptsContentType(header string) bool {
+	if header == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range f.contentTypes {
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// download guarda el archivo en dst y devuelve el nombre del archivo en la URL
+// final. Si supera maxSize corta la descarga y devuelve un *policyError.
+func (f *urlFetcher) download(ctx context.Context, u *url.URL, dst io.Writer, policy uploadPolicy) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", errURLInvalid
+	}
+	req.Header.Set("User-Agent", "wasabi-url-import")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: respuesta %d", errURLDownloadFailed, resp.StatusCode)
+	}
+	if !f.acceptsContentType(resp.Header.Get("Content-Type")) {
+		return "", fmt.Errorf("%w: %s", errURLContentType, resp.Header.Get("Content-Type"))
+	}
+	if f.maxSize > 0 && resp.ContentLength > f.maxSize {
+		return "", policy.fileTooLarge(resp.ContentLength)
+	}
+
+	body := io.Reader(resp.Body)
+	if f.maxSize > 0 {
+		body = io.LimitReader(resp.Body, f.maxSize+1)
+	}
+	n, err := io.Copy(dst, body)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errURLDownloadFailed, err)
+	}
+	if f.maxSize > 0 && n > f.maxSize {
+		return "", policy.fileTooLarge(n)
+	}
+
+	// La URL final, después de redirecciones, suele tener el mejor nombre.
+	return path.Base(resp.Request.URL.Path), nil
+}
+
+func mustParseCIDRs(values ...string) []*net.IPNet {
+	nets, err := parseCIDRs(values)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
+
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(values))
+	for _, raw := range values {
+		if !strings.Contains(raw, "/") {
+			if ip := net.ParseIP(raw); ip != nil && ip.To4() != nil {
+				raw += "/32"
+			} else {
+				raw += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(raw)
+		if err != nil {
+			return nil, fmt.Errorf("rango inválido %q", raw)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
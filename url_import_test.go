@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testImportMaxSize = 1024
+
+// newTestFetcher arma el fetcher desde las variables de entorno, como al
+// iniciar el servidor.
+func newTestFetcher(t *testing.T, allow string) (*urlFetcher, uploadPolicy) {
+	t.Helper()
+	t.Setenv("URL_IMPORT_ALLOW_CIDRS", allow)
+	t.Setenv("URL_IMPORT_DENY_CIDRS", "")
+	t.Setenv("URL_IMPORT_CONTENT_TYPES", "")
+	t.Setenv("URL_IMPORT_MAX_SIZE", "")
+	t.Setenv("URL_IMPORT_TIMEOUT", "")
+
+	policy := uploadPolicy{MaxFileSize: testImportMaxSize}
+	cfg, err := readURLImportConfig(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newURLFetcher(cfg), policy
+}
+
+func fetch(t *testing.T, f *urlFetcher, policy uploadPolicy, raw string) (string, []byte, error) {
+	t.Helper()
+	u, err := parseImportURL(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	name, err := f.download(context.Background(), u, &buf, policy)
+	return name, buf.Bytes(), err
+}
+
+func newAudioServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sonidos/hola.mp3", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write([]byte("ID3 datos"))
+	})
+	mux.HandleFunc("/pagina", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html></html>"))
+	})
+	mux.HandleFunc("/grande", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Header().Set("Content-Length", strconv.Itoa(testImportMaxSize*4))
+		w.Write(bytes.Repeat([]byte{1}, testImportMaxSize*4))
+	})
+	mux.HandleFunc("/grande-sin-largo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		// Con Flush la respuesta va fragmentada y sin Content-Length.
+		for i := 0; i < 8; i++ {
+			w.Write(bytes.Repeat([]byte{1}, testImportMaxSize/2))
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.HandleFunc("/bucle", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+		http.Redirect(w, r, "/bucle?n="+strconv.Itoa(n+1), http.StatusFound)
+	})
+	mux.HandleFunc("/redirigir", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Query().Get("a"), http.StatusFound)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestURLImportBlocksLoopback(t *testing.T) {
+	srv := newAudioServer(t)
+	f, policy := newTestFetcher(t, "")
+
+	_, _, err := fetch(t, f, policy, srv.URL+"/sonidos/hola.mp3")
+	if !errors.Is(err, errURLBlocked) {
+		t.Fatalf("se esperaba errURLBlocked, se obtuvo %v", err)
+	}
+
+	// Con localhost la IP se revisa igual después de resolver el nombre.
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	_, _, err = fetch(t, f, policy, "http://localhost:"+port+"/sonidos/hola.mp3")
+	if !errors.Is(err, errURLBlocked) {
+		t.Fatalf("localhost: se esperaba errURLBlocked, se obtuvo %v", err)
+	}
+}
+
+func TestURLImportAllowCIDRs(t *testing.T) {
+	srv := newAudioServer(t)
+	f, policy := newTestFetcher(t, "127.0.0.0/8")
+
+	name, data, err := fetch(t, f, policy, srv.URL+"/sonidos/hola.mp3")
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if name != "hola.mp3" || string(data) != "ID3 datos" {
+		t.Errorf("download = %q, %q", name, data)
+	}
+}
+
+func TestURLImportRedirectToPrivateAddress(t *testing.T) {
+	srv := newAudioServer(t)
+	// Solo se permite la IP del servidor de prueba; 127.0.0.2 sigue bloqueada.
+	f, policy := newTestFetcher(t, "127.0.0.1")
+
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	target := "http://127.0.0.2:" + port + "/sonidos/hola.mp3"
+	_, _, err := fetch(t, f, policy, srv.URL+"/redirigir?a="+url.QueryEscape(target))
+	if !errors.Is(err, errURLBlocked) {
+		t.Fatalf("se esperaba errURLBlocked en el segundo salto, se obtuvo %v", err)
+	}
+
+	// Una redirección dentro de lo permitido sí se sigue.
+	name, _, err := fetch(t, f, policy, srv.URL+"/redirigir?a="+url.QueryEscape("/sonidos/hola.mp3"))
+	if err != nil || name != "hola.mp3" {
+		t.Fatalf("redirección permitida: name=%q err=%v", name, err)
+	}
+}
+
+func TestURLImportRedirectLimit(t *testing.T) {
+	srv := newAudioServer(t)
+	f, policy := newTestFetcher(t, "127.0.0.0/8")
+
+	_, _, err := fetch(t, f, policy, srv.URL+"/bucle")
+	if !errors.Is(err, errURLTooManyRedirect) {
+		t.Fatalf("se esperaba errURLTooManyRedirect, se obtuvo %v", err)
+	}
+}
+
+func TestURLImportSizeLimit(t *testing.T) {
+	srv := newAudioServer(t)
+	f, policy := newTestFetcher(t, "127.0.0.0/8")
+
+	for _, path := range []string{"/grande", "/grande-sin-largo"} {
+		_, data, err := fetch(t, f, policy, srv.URL+path)
+		var rejected *policyError
+		if !errors.As(err, &rejected) || rejected.violations[0].Code != "file_too_large" {
+			t.Errorf("%s: se esperaba file_too_large, se obtuvo %v", path, err)
+		}
+		if len(data) > testImportMaxSize+1 {
+			t.Errorf("%s: se guardaron %d bytes, más del límite", path, len(data))
+		}
+	}
+}
+
+func TestURLImportContentType(t *testing.T) {
+	srv := newAudioServer(t)
+	f, policy := newTestFetcher(t, "127.0.0.0/8")
+
+	_, data, err := fetch(t, f, policy, srv.URL+"/pagina")
+	if !errors.Is(err, errURLContentType) {
+		t.Fatalf("se esperaba errURLContentType, se obtuvo %v", err)
+	}
+	if len(data) != 0 {
+		t.Errorf("no debería guardarse nada, se guardaron %d bytes", len(data))
+	}
+}
+
+func TestAddressPolicy(t *testing.T) {
+	policy := addressPolicy{
+		allow: mustParseCIDRs("10.1.0.0/16"),
+		deny:  mustParseCIDRs("203.0.113.0/24"),
+	}
+	cases := map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"10.1.2.3":        true,
+		"10.2.0.1":        false,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"fd00::1":         false,
+		"203.0.113.7":     false,
+	}
+	for raw, want := range cases {
+		if got := policy.allowed(net.ParseIP(raw)); got != want {
+			t.Errorf("allowed(%s) = %v, se esperaba %v", raw, got, want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// waveformResolution es la cantidad de picos que se guardan por sonido;
+	// el endpoint los reduce a los que pida el cliente.
+	waveformResolution = 1000
+	waveformSampleRate = 8000
+	defaultWaveformPts = 200
+)
+
+// waveformPeaks decodifica el audio a PCM mono de 16 bits y devuelve el pico
+// absoluto de cada tramo, normalizado entre 0 y 1. Los tramos se calculan con
+// la duración medida por ffprobe para procesar las muestras a medida que
+// llegan, con memoria fija sin importar el largo del audio.
+func (f *ffmpegRunner) waveformPeaks(ctx context.Context, path string, duration float64) ([]float64, error) {
+	if duration <= 0 {
+		return nil, fmt.Errorf("%w: duración desconocida", errUnreadableAudio)
+	}
+
+	acc := newPeakAccumulator(int64(math.Ceil(duration * waveformSampleRate)))
+	err := f.ffmpegStream(ctx, acc, "-i", path, "-vn", "-ac", "1", "-ar", strconv.Itoa(waveformSampleRate),
+		"-f", "s16le", "-acodec", "pcm_s16le", "-")
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg no pudo decodificar el audio: %w", err)
+	}
+	return acc.result()
+}
+
+// peakAccumulator recibe PCM s16le y guarda solo el máximo de cada tramo.
+type peakAccumulator struct {
+	expected int64
+	samples  int64
+	peaks    []float64
+	last     int
+	// Un byte suelto si una escritura cortó una muestra a la mitad.
+	carry    byte
+	hasCarry bool
+}
+
+func newPeakAccumulator(expected int64) *peakAccumulator {
+	expected = max(expected, 1)
+	return &peakAccumulator{
+		expected: expected,
+		peaks:    make([]float64, min(int64(waveformResolution), expected)),
+	}
+}
+
+func (a *peakAccumulator) Write(p []byte) (int, error) {
+	n := len(p)
+	if a.hasCarry && len(p) > 0 {
+		a.add(int16(uint16(a.carry) | uint16(p[0])<<8))
+		a.hasCarry = false
+		p = p[1:]
+	}
+	for ; len(p) >= 2; p = p[2:] {
+		a.add(int16(binary.LittleEndian.Uint16(p)))
+	}
+	if len(p) == 1 {
+		a.carry, a.hasCarry = p[0], true
+	}
+	return n, nil
+}
+
+func (a *peakAccumulator) add(sample int16) {
+	// Si ffprobe subestimó la duración, lo que sobra cae en el último tramo.
+	bucket := int(min(a.samples*int64(len(a.peaks))/a.expected, int64(len(a.peaks)-1)))
+	a.peaks[bucket] = max(a.peaks[bucket], math.Abs(float64(sample))/32768)
+	a.last = bucket
+	a.samples++
+}
+
+func (a *peakAccumulator) result() ([]float64, error) {
+	if a.samples == 0 {
+		return nil, fmt.Errorf("%w: sin muestras", errUnreadableAudio)
+	}
+	// Si la sobreestimó, se descartan los tramos que no recibieron muestras.
+	peaks := a.peaks[:a.last+1]
+	for i, v := range peaks {
+		peaks[i] = math.Round(v*1000) / 1000
+	}
+	return peaks, nil
+}
+
+// downsamplePeaks agrupa los picos guardados conservando el máximo de cada grupo.
+func downsamplePeaks(peaks []float64, points int) []float64 {
+	if points >= len(peaks) {
+		return peaks
+	}
+	out := make([]float64, points)
+	for i, v := range peaks {
+		bucket := i * points / len(peaks)
+		out[bucket] = max(out[bucket], v)
+	}
+	return out
+}
+
+// soundPeaks devuelve los picos guardados o los genera para sonidos subidos
+// antes de que existiera la forma de onda.
+func (s *server) soundPeaks(ctx context.Context, rec soundRecord) ([]float64, error) {
+	if len(rec.Peaks) > 0 {
+		return rec.Peaks, nil
+	}
+
+	release, err := s.tryAcquireTranscode()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	inputPath, err := s.downloadToTemp(ctx, rec.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(inputPath)
+
+	duration := rec.Audio.Duration
+	if duration <= 0 {
+		info, err := s.ffmpeg.probeAudio(ctx, inputPath)
+		if err != nil {
+			return nil, err
+		}
+		duration = info.Duration
+	}
+
+	peaks, err := s.ffmpeg.waveformPeaks(ctx, inputPath, duration)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.soundsCollection.UpdateOne(ctx, bson.M{"name": rec.Name}, bson.M{"$set": bson.M{"peaks": peaks}}); err != nil {
+		log.Printf("no se pudo guardar forma de onda de %s: %v", rec.Name, err)
+	}
+	return peaks, nil
+}
+
+func (s *server) waveformHandler(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "solo se permite GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	points := defaultWaveformPts
+	if raw := strings.TrimSpace(r.URL.Query().Get("points")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > waveformResolution {
+			http.Error(w, fmt.Sprintf("points debe ser un entero entre 1 y %d", waveformResolution), http.StatusBadRequest)
+			return
+		}
+		points = n
+	}
+
+	rec, err := s.findSound(r.Context(), name)
+	if errors.Is(err, errSoundNotFound) || (err == nil && rec.Status == soundProcessing) {
+		http.Error(w, "archivo no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error al consultar catálogo: %v", err)
+		http.Error(w, "no se pudo leer la forma de onda", http.StatusInternalServerError)
+		return
+	}
+
+	peaks, err := s.soundPeaks(r.Context(), rec)
+	if errors.Is(err, errObjectNotFound) {
+		http.Error(w, "archivo no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error al generar forma de onda de %s: %v", name, err)
+		writeProcessingError(w, err, "no se pudo generar la forma de onda")
+		return
+	}
+
+	peaks = downsamplePeaks(peaks, points)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"name":     name,
+		"duration": rec.Audio.Duration,
+		"points":   len(peaks),
+		"peaks":    peaks,
+	})
+}
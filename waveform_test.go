@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func pcm(samples ...int16) []byte {
+	out := make([]byte, 0, len(samples)*2)
+	for _, v := range samples {
+		out = binary.LittleEndian.AppendUint16(out, uint16(v))
+	}
+	return out
+}
+
+func TestPeakAccumulator(t *testing.T) {
+	t.Run("muestras cortadas entre escrituras", func(t *testing.T) {
+		acc := newPeakAccumulator(4)
+		data := pcm(16384, -32768, 8192, -16384)
+		for _, b := range data {
+			acc.Write([]byte{b})
+		}
+		peaks, err := acc.result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []float64{0.5, 1, 0.25, 0.5}
+		if len(peaks) != len(want) {
+			t.Fatalf("peaks = %v, se esperaba %v", peaks, want)
+		}
+		for i := range want {
+			if peaks[i] != want[i] {
+				t.Fatalf("peaks = %v, se esperaba %v", peaks, want)
+			}
+		}
+	})
+
+	t.Run("duración subestimada", func(t *testing.T) {
+		acc := newPeakAccumulator(2)
+		acc.Write(pcm(0, 0, 0, 32767))
+		peaks, _ := acc.result()
+		if len(peaks) != 2 || peaks[1] != 1 {
+			t.Errorf("lo que sobra debe caer en el último tramo: %v", peaks)
+		}
+	})
+
+	t.Run("duración sobreestimada", func(t *testing.T) {
+		acc := newPeakAccumulator(waveformResolution * 4)
+		acc.Write(pcm(make([]int16, waveformResolution*2)...))
+		peaks, _ := acc.result()
+		if len(peaks) != waveformResolution/2 {
+			t.Errorf("se esperaban %d tramos con muestras, hay %d", waveformResolution/2, len(peaks))
+		}
+	})
+
+	t.Run("sin muestras", func(t *testing.T) {
+		if _, err := newPeakAccumulator(10).result(); err == nil {
+			t.Error("se esperaba error sin muestras")
+		}
+	})
+}